@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Jeffail/gabs"
+	"github.com/aranajuanm/dsScroller/ds"
+)
+
+// checkpoint is the state persisted after every page of an export, enough
+// to pick it up again with -resume after a crash.
+type checkpoint struct {
+	path string
+
+	// Query is the search body as loaded, before any scroll_id was added.
+	Query json.RawMessage `json:"query"`
+	// PageSize is the size sent with the first request of a scroll.
+	PageSize int `json:"page_size"`
+
+	ScrollID  string `json:"scroll_id"`
+	Pages     int    `json:"pages"`
+	Documents int    `json:"documents"`
+	// Offset is the size of the output file once the last page was written,
+	// or its number of rows for sqlite.
+	Offset int64 `json:"offset"`
+	// Total is how many documents the export was expected to write.
+	Total int64 `json:"total,omitempty"`
+
+	// SortField and LastSort let an expired scroll be re-queried from the
+	// last document written, when the query is sorted.
+	SortField string      `json:"sort_field,omitempty"`
+	SortDesc  bool        `json:"sort_desc,omitempty"`
+	LastSort  interface{} `json:"last_sort,omitempty"`
+
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func checkpointPath(out string) string {
+	return out + ".checkpoint"
+}
+
+// newCheckpoint starts the checkpoint of a fresh export of request.
+func newCheckpoint(path string, request *gabs.Container, pageSize int) *checkpoint {
+	cp := &checkpoint{
+		path:     path,
+		Query:    json.RawMessage(request.Bytes()),
+		PageSize: pageSize,
+	}
+	cp.SortField, cp.SortDesc = sortKey(request)
+	return cp
+}
+
+func loadCheckpoint(path string) (*checkpoint, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("nothing to resume, %s does not exist", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The last sort value is decoded as a json.Number, which keeps the
+	// digits of an id above 2^53 for the range of cursorRequest.
+	cp := &checkpoint{path: path}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(cp); err != nil {
+		return nil, fmt.Errorf("corrupt checkpoint %s: %v", path, err)
+	}
+	return cp, nil
+}
+
+// advance records a page that has been fully written to the output.
+func (cp *checkpoint) advance(page *ds.Page, offset int64) {
+	docs := page.Documents
+	cp.ScrollID = page.ScrollID
+	cp.Pages++
+	cp.Documents += len(docs)
+	cp.Offset = offset
+	if cp.SortField != "" && len(docs) > 0 {
+		cp.LastSort = docs[len(docs)-1].Path(cp.SortField).Data()
+	}
+}
+
+// save writes the checkpoint atomically, so a crash while saving leaves the
+// previous one in place.
+func (cp *checkpoint) save() error {
+	cp.UpdatedAt = time.Now()
+	b, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(cp.path, b)
+}
+
+// writeFileAtomic writes b to a temporary file synced to disk and renames
+// it to path.
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (cp *checkpoint) remove() error {
+	err := os.Remove(cp.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// resumeRequest is the request that continues the scroll where the
+// checkpoint left it.
+func (cp *checkpoint) resumeRequest() (*gabs.Container, error) {
+	if cp.ScrollID == "" {
+		return cp.freshRequest()
+	}
+	request, err := parseQuery(cp.Query)
+	if err != nil {
+		return nil, err
+	}
+	request.Set("scroll", "type")
+	request.Set(cp.ScrollID, "scroll_id")
+	request.Delete("size")
+	return request, nil
+}
+
+// cursorRequest starts a new scroll that only matches the documents after
+// the last one written, for when the checkpointed scroll has expired.
+func (cp *checkpoint) cursorRequest() (*gabs.Container, error) {
+	if cp.Pages == 0 {
+		return cp.freshRequest()
+	}
+	if cp.SortField == "" {
+		return nil, errors.New(`the query has no "sort", so the export cannot continue from its last document`)
+	}
+	if cp.LastSort == nil {
+		return nil, fmt.Errorf("the last document has no %q, add it to the projections to resume from it", cp.SortField)
+	}
+
+	request, err := cp.freshRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	op := "gt"
+	if cp.SortDesc {
+		op = "lt"
+	}
+	after := map[string]interface{}{
+		"range": map[string]interface{}{"field": cp.SortField, op: cp.LastSort},
+	}
+	request.Set(map[string]interface{}{
+		"and": []interface{}{request.Path("query").Data(), after},
+	}, "query")
+	return request, nil
+}
+
+func (cp *checkpoint) freshRequest() (*gabs.Container, error) {
+	request, err := parseQuery(cp.Query)
+	if err != nil {
+		return nil, err
+	}
+	request.Set("scroll", "type")
+	request.Set(cp.PageSize, "size")
+	request.Delete("scroll_id")
+	return request, nil
+}
+
+// sortKey returns the first sort field of the query, given either as
+// {"field": "id", "order": "asc"} or as a list of those.
+func sortKey(request *gabs.Container) (string, bool) {
+	sort := request.S("sort")
+	if _, ok := sort.Data().([]interface{}); ok {
+		sort = sort.Index(0)
+	}
+	field, _ := sort.Path("field").Data().(string)
+	order, _ := sort.Path("order").Data().(string)
+	return field, order == "desc"
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Jeffail/gabs"
+)
+
+// column maps one field of a DS document to one output column.
+type column struct {
+	// Path is the dotted gabs path of the field, e.g. "payer.id".
+	Path string
+	// Format is an optional fmt verb applied to the raw value, e.g. "%.2f".
+	Format string
+	// Default is written when the field is missing or null.
+	Default string
+}
+
+// parseColumns parses a column spec: a comma separated list of
+// path[:format[:default]] entries, e.g. "id,amount:%.2f,status::unknown".
+func parseColumns(spec string) ([]column, error) {
+	var columns []column
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		c := column{Path: parts[0]}
+		if len(parts) > 1 {
+			c.Format = parts[1]
+		}
+		if len(parts) > 2 {
+			c.Default = parts[2]
+		}
+		if c.Path == "" {
+			return nil, fmt.Errorf("column %q has no path", entry)
+		}
+		columns = append(columns, c)
+	}
+	if len(columns) == 0 {
+		return nil, errors.New("column spec is empty")
+	}
+	return columns, nil
+}
+
+// projectionColumns builds one column per entry of the query projections.
+func projectionColumns(request *gabs.Container) ([]column, error) {
+	projections, _ := request.S("projections").Children()
+	if len(projections) == 0 {
+		return nil, errors.New(`query has no "projections", pass -columns to pick the fields`)
+	}
+
+	columns := make([]column, 0, len(projections))
+	for _, p := range projections {
+		path, ok := p.Data().(string)
+		if !ok || path == "" {
+			return nil, fmt.Errorf("projection %s is not a field name", p.String())
+		}
+		columns = append(columns, column{Path: path})
+	}
+	return columns, nil
+}
+
+// value renders the column for doc as text.
+func (c column) value(doc *gabs.Container) string {
+	data := doc.Path(c.Path).Data()
+	if data == nil {
+		return c.Default
+	}
+	if c.Format != "" {
+		return formatData(c.Format, data)
+	}
+	return formatValue(data)
+}
+
+var verbPattern = regexp.MustCompile(`%[-+# 0]*[0-9]*(?:\.[0-9]*)?([a-zA-Z])`)
+
+// formatData formats data with a column format. Numbers parsed as
+// json.Number are formatted as the int64 or float64 the verb expects.
+func formatData(format string, data interface{}) string {
+	if n, ok := data.(json.Number); ok {
+		verb := ""
+		if m := verbPattern.FindStringSubmatch(format); m != nil {
+			verb = m[1]
+		}
+		switch {
+		case strings.Contains("dboxXc", verb):
+			if i, err := n.Int64(); err == nil {
+				data = i
+			} else if f, err := n.Float64(); err == nil {
+				data = int64(f)
+			}
+		case strings.Contains("eEfFgG", verb):
+			if f, err := n.Float64(); err == nil {
+				data = f
+			}
+		}
+	}
+	return fmt.Sprintf(format, data)
+}
+
+func formatValue(data interface{}) string {
+	switch v := data.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(b)
+	}
+}
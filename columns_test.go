@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/Jeffail/gabs"
+)
+
+func TestParseColumns(t *testing.T) {
+	for _, c := range []struct {
+		spec string
+		want []column
+		err  bool
+	}{
+		{spec: "id", want: []column{{Path: "id"}}},
+		{spec: "id,amount:%.2f,status::unknown", want: []column{
+			{Path: "id"},
+			{Path: "amount", Format: "%.2f"},
+			{Path: "status", Default: "unknown"},
+		}},
+		{spec: " user.id , ,date_created:%s:never", want: []column{
+			{Path: "user.id"},
+			{Path: "date_created", Format: "%s", Default: "never"},
+		}},
+		{spec: "note::a:b", want: []column{{Path: "note", Default: "a:b"}}},
+		{spec: "", err: true},
+		{spec: " , ", err: true},
+		{spec: "id,:%d", err: true},
+	} {
+		got, err := parseColumns(c.spec)
+		if c.err {
+			if err == nil {
+				t.Errorf("parseColumns(%q) = %+v, want an error", c.spec, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseColumns(%q): %v", c.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("parseColumns(%q) = %+v, want %+v", c.spec, got, c.want)
+		}
+	}
+}
+
+func TestColumnValue(t *testing.T) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(
+		`{"id":9007199254740993,"amount":12.5,"status":"ok","paid":true,"user":{"id":7},"tags":["a"],"note":null}`)))
+	decoder.UseNumber()
+	var data interface{}
+	if err := decoder.Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := gabs.Consume(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		column column
+		want   string
+	}{
+		{column{Path: "id"}, "9007199254740993"},
+		{column{Path: "id", Format: "%d"}, "9007199254740993"},
+		{column{Path: "id", Format: "%x"}, "20000000000001"},
+		{column{Path: "amount"}, "12.5"},
+		{column{Path: "amount", Format: "%.2f"}, "12.50"},
+		{column{Path: "user.id", Format: "%03d"}, "007"},
+		{column{Path: "status"}, "ok"},
+		{column{Path: "status", Format: "[%s]"}, "[ok]"},
+		{column{Path: "paid"}, "true"},
+		{column{Path: "tags"}, `["a"]`},
+		{column{Path: "note", Default: "none"}, "none"},
+		{column{Path: "missing", Default: "none"}, "none"},
+		{column{Path: "missing"}, ""},
+	} {
+		if got := c.column.value(doc); got != c.want {
+			t.Errorf("%+v: value = %q, want %q", c.column, got, c.want)
+		}
+	}
+}
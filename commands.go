@@ -0,0 +1,19 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+)
+
+func runValidate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	query := addQueryFlags(fs)
+	fs.Parse(args)
+
+	if _, err := query.load(); err != nil {
+		return err
+	}
+	fmt.Printf("%s: OK\n", query.ref)
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressionExts are the file extensions of the -compress algorithms.
+var compressionExts = map[string]string{
+	"gzip": ".gz",
+	"zstd": ".zst",
+}
+
+// frameEncoder is what gzip.Writer and zstd.Encoder have in common.
+type frameEncoder interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// partFile is one output file. When compressed, every page is its own
+// gzip member or zstd frame, so the file can be cut after any page and
+// still be valid, which is what -resume relies on.
+type partFile struct {
+	file  *os.File
+	n     int64
+	hash  hash.Hash
+	enc   frameEncoder
+	dirty bool
+}
+
+func createPart(name string, compress string) (*partFile, error) {
+	file, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return newPart(file, 0, compress)
+}
+
+// reopenPart opens an existing part to append to it after offset. A part
+// shorter than offset lost pages the checkpoint has, in a crash of the host
+// before they were synced, and is not reopened.
+func reopenPart(name string, compress string, offset int64) (*partFile, error) {
+	file, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if fi.Size() < offset {
+		file.Close()
+		return nil, fmt.Errorf("%s has %d bytes, fewer than the %d the checkpoint says were written, start the export again without -resume", name, fi.Size(), offset)
+	}
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return newPart(file, offset, compress)
+}
+
+func newPart(file *os.File, offset int64, compress string) (*partFile, error) {
+	p := &partFile{file: file, n: offset, hash: sha256.New()}
+	var err error
+	switch compress {
+	case "":
+	case "gzip":
+		p.enc = gzip.NewWriter(rawWriter{p})
+	case "zstd":
+		p.enc, err = zstd.NewWriter(rawWriter{p})
+	default:
+		err = fmt.Errorf("unknown compression %q, use gzip or zstd", compress)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *partFile) Write(b []byte) (int, error) {
+	if p.enc == nil {
+		return p.writeRaw(b)
+	}
+	p.dirty = true
+	return p.enc.Write(b)
+}
+
+func (p *partFile) writeRaw(b []byte) (int, error) {
+	n, err := p.file.Write(b)
+	p.n += int64(n)
+	p.hash.Write(b[:n])
+	return n, err
+}
+
+// endPage closes the current gzip member or zstd frame.
+func (p *partFile) endPage() error {
+	if p.enc == nil || !p.dirty {
+		return nil
+	}
+	p.dirty = false
+	if err := p.enc.Close(); err != nil {
+		return err
+	}
+	p.enc.Reset(rawWriter{p})
+	return nil
+}
+
+// size is how many bytes of the file have been written so far.
+func (p *partFile) size() int64 {
+	return p.n
+}
+
+// Close ends the part and syncs it to disk.
+func (p *partFile) Close() error {
+	if err := p.endPage(); err != nil {
+		p.file.Close()
+		return err
+	}
+	if err := p.file.Sync(); err != nil {
+		p.file.Close()
+		return err
+	}
+	return p.file.Close()
+}
+
+// rawWriter writes to the file of a part, under its compression.
+type rawWriter struct {
+	p *partFile
+}
+
+func (w rawWriter) Write(b []byte) (int, error) {
+	return w.p.writeRaw(b)
+}
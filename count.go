@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/Jeffail/gabs"
+	"github.com/aranajuanm/dsScroller/ds"
+)
+
+func runCount(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("count", flag.ExitOnError)
+	url := fs.String("url", "", "DS search URL of the read proxy (required)")
+	tokens := addTokenFlags(fs)
+	query := addQueryFlags(fs)
+	addRequestFlags(fs)
+	profile := addProfileFlag(fs)
+	fs.Parse(args)
+	if err := applyProfile(fs, *profile); err != nil {
+		return err
+	}
+
+	if *url == "" {
+		return errors.New("-url is required, or a -profile with one")
+	}
+	token, err := tokens.resolve()
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		return errors.New("a token is required: -token, -token-file, -token-command or $" + tokenEnv)
+	}
+
+	request, err := query.load()
+	if err != nil {
+		return err
+	}
+	total, _, err := count(ctx, *url, token, request)
+	if err != nil {
+		return err
+	}
+	fmt.Println(total)
+	return nil
+}
+
+// count sends request as a plain search with size 0 and returns how many
+// documents it matches and how long DS took to answer.
+func count(ctx context.Context, url string, token string, request *gabs.Container) (int64, time.Duration, error) {
+	countRequest := copyRequest(request)
+	countRequest.Delete("type")
+	countRequest.Delete("scroll_id")
+	countRequest.Delete("slice")
+	countRequest.Set(0, "size")
+
+	start := time.Now()
+	response, err := search(ctx, url, token, countRequest)
+	if err != nil {
+		return 0, 0, err
+	}
+	total, ok := ds.Total(response)
+	if !ok {
+		return 0, 0, &ds.MalformedResponseError{Body: response.String(), Err: errors.New("response has no total")}
+	}
+	return total, time.Since(start), nil
+}
+
+// dryRun prints the requests an export would send and, when it can reach
+// DS, how many documents it would get and an estimate of how long it would
+// take.
+func dryRun(ctx context.Context, o exportOptions, query *queryFlags) error {
+	request, err := loadScrollRequest(query, o.size)
+	if err != nil {
+		return err
+	}
+	requests := []*gabs.Container{request}
+	if o.slices > 1 {
+		if requests, err = sliceQuery(request, o.sliceBy, o.sliceField, o.slices); err != nil {
+			return err
+		}
+	}
+	if o.window != "" {
+		if requests, _, err = windowQuery(request, o.windowField, o.window, o.windowTZ); err != nil {
+			return err
+		}
+	}
+	if _, err := o.columns(request); err != nil {
+		return err
+	}
+
+	for i, r := range requests {
+		if len(requests) > 1 && o.window != "" {
+			fmt.Printf("window %d:\n", i)
+		} else if len(requests) > 1 {
+			fmt.Printf("slice %d:\n", i)
+		}
+		fmt.Println(r.StringIndent("", "  "))
+	}
+
+	if o.url == "" || o.token == "" {
+		fmt.Println("pass -url and a token to count the documents and estimate the export duration")
+		return nil
+	}
+
+	total, latency, err := count(ctx, o.url, o.token, request)
+	if err != nil {
+		return err
+	}
+	pages := int64(math.Ceil(float64(total)/float64(o.size))) + int64(len(requests))
+	perPage := latency + time.Duration(o.sleep)*time.Millisecond
+	parallel := o.workers
+	switch {
+	case o.window != "":
+		// Windows are exported one after the other.
+		parallel = 1
+	case parallel <= 0 || parallel > len(requests):
+		parallel = len(requests)
+	}
+	estimate := time.Duration(pages) * perPage / time.Duration(parallel)
+	if o.rpm > 0 {
+		if limited := time.Duration(float64(pages) / float64(o.rpm) * float64(time.Minute)); limited > estimate {
+			estimate = limited
+		}
+	}
+
+	fmt.Printf("documents: %d\n", total)
+	fmt.Printf("pages:     %d (up to %d documents each)\n", pages, o.size)
+	fmt.Printf("estimate:  %v (%v per page, %d in parallel)\n", estimate.Round(100*time.Millisecond), perPage.Round(time.Millisecond), parallel)
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aranajuanm/dsScroller/ds/dstest"
+)
+
+// capture returns what f prints to file, os.Stdout or os.Stderr.
+func capture(t *testing.T, file **os.File, f func() error) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := *file
+	*file = w
+	out := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		out <- b
+	}()
+	err = f()
+	*file = saved
+	w.Close()
+	return string(<-out), err
+}
+
+func TestDryRun(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	srv := dstest.NewServer(dstest.Documents(100))
+	defer srv.Close()
+
+	query := filepath.Join(dir, "query.json")
+	body := `{"query":{"and":[{"date_range":{"field":"date_created","gte":"2019-01-01","lt":"2019-01-04","time_zone":"-04:00"}}]},"projections":["id","date_created"]}`
+	if err := ioutil.WriteFile(query, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		args     []string
+		requests string
+		parallel string
+	}{
+		{nil, `"type": "scroll"`, "1 in parallel"},
+		{[]string{"-slices", "3", "-slice-by", "server"}, "slice 2:", "3 in parallel"},
+		{[]string{"-slices", "3", "-slice-by", "server", "-workers", "2"}, "slice 2:", "2 in parallel"},
+		// The 3 windows of a day are exported one at a time.
+		{[]string{"-window", "day"}, "window 2:", "1 in parallel"},
+		{[]string{"-window", "day", "-workers", "3"}, "window 2:", "1 in parallel"},
+	} {
+		args := append([]string{"-url", srv.URL, "-token", "xyzzy", "-query", query, "-size", "10", "-sleep", "0", "-dry-run"}, c.args...)
+		out, err := capture(t, &os.Stdout, func() error { return runExport(context.Background(), args) })
+		if err != nil {
+			t.Errorf("dry run with %v: %v", c.args, err)
+			continue
+		}
+		for _, want := range []string{c.requests, "documents: 72\n", c.parallel} {
+			if !strings.Contains(out, want) {
+				t.Errorf("dry run with %v printed\n%s\nwant %q", c.args, out, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+
+	"github.com/Jeffail/gabs"
+)
+
+// csvWriter writes one CSV row per document using a column mapping.
+type csvWriter struct {
+	w       *csv.Writer
+	c       io.Closer
+	columns []column
+}
+
+func newCSVWriter(w io.WriteCloser, columns []column, header bool) (*csvWriter, error) {
+	cw := &csvWriter{w: csv.NewWriter(w), c: w, columns: columns}
+	if header {
+		names := make([]string, len(columns))
+		for i, c := range columns {
+			names[i] = c.Path
+		}
+		if err := cw.w.Write(names); err != nil {
+			return nil, err
+		}
+		cw.w.Flush()
+		if err := cw.w.Error(); err != nil {
+			return nil, err
+		}
+	}
+	return cw, nil
+}
+
+func (cw *csvWriter) WritePage(docs []*gabs.Container) error {
+	row := make([]string, len(cw.columns))
+	for _, doc := range docs {
+		for i, c := range cw.columns {
+			row[i] = c.value(doc)
+		}
+		if err := cw.w.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	if err := cw.w.Error(); err != nil {
+		cw.c.Close()
+		return err
+	}
+	return cw.c.Close()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aranajuanm/dsScroller/ds"
+)
+
+// bloomFalsePositives is the rate at which the -dedupe bloom filter takes a
+// new document for one written before, and drops it.
+const bloomFalsePositives = 1e-7
+
+// seenIDs and idField drop the documents written before by id, nil when
+// there is no -dedupe.
+var (
+	seenIDs ds.IDSet
+	idField string
+)
+
+func idsPath(out string) string {
+	return out + ".ids"
+}
+
+// openDedupe sets seenIDs up for -dedupe. The bloom filter of a fresh
+// export starts empty, the one of a -resume is read from its file.
+func openDedupe(o exportOptions) error {
+	idField = o.idField
+	switch o.dedupe {
+	case "":
+		return nil
+	case "memory":
+		if o.resume {
+			fmt.Fprintln(os.Stderr, "-dedupe memory starts empty on -resume, use -dedupe bloom to catch documents written before it")
+		}
+		seenIDs = ds.NewMemorySet(o.dedupeMax)
+		return nil
+	case "bloom":
+		if !o.resume {
+			if err := os.Remove(idsPath(o.out)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		bloom, err := ds.OpenBloomFilter(idsPath(o.out), o.dedupeMax, bloomFalsePositives)
+		if err != nil {
+			return err
+		}
+		if o.resume && !bloom.Loaded() {
+			fmt.Fprintf(os.Stderr, "%s not found, documents written before -resume will not be caught\n", idsPath(o.out))
+		}
+		seenIDs = bloom
+		return nil
+	}
+	return fmt.Errorf("unknown -dedupe %q, use memory or bloom", o.dedupe)
+}
+
+// syncDedupe saves the ids seen so far, for a -resume to know them.
+func syncDedupe() error {
+	if bloom, ok := seenIDs.(*ds.BloomFilter); ok {
+		return bloom.Sync()
+	}
+	return nil
+}
+
+// closeDedupe closes the bloom filter, removing its file unless keep. A
+// kept filter is for a -resume of a failed export, so it only keeps the ids
+// synced with the checkpoint: those added since are of a page that failed
+// to be written, which the -resume asks for again.
+func closeDedupe(o exportOptions, keep bool) error {
+	bloom, ok := seenIDs.(*ds.BloomFilter)
+	if !ok {
+		return nil
+	}
+	if err := bloom.Discard(); err != nil || keep {
+		return err
+	}
+	return os.Remove(idsPath(o.out))
+}
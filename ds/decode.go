@@ -0,0 +1,104 @@
+package ds
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/Jeffail/gabs"
+)
+
+// DecodeError is returned when a document can't be decoded into the type
+// asked for.
+type DecodeError struct {
+	// Index is the position of the document in its page, from 0.
+	Index int
+	// ID is the id of the document, empty when it has none.
+	ID string
+	// Path is the dotted path of the field that didn't fit its type, empty
+	// when the error is not about one field.
+	Path string
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	if e.ID == "" {
+		return fmt.Sprintf("document %d: %v", e.Index, e.Err)
+	}
+	return fmt.Sprintf("document %d (id %s): %v", e.Index, e.ID, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Decode decodes doc into v, a pointer, as encoding/json would, with the
+// numbers of interface{} values as json.Number. Fields of v that doc lacks
+// are left alone, and fields of doc that v lacks are ignored.
+//
+// Numbers keep their digits into int64, uint64 and json.Number fields when
+// doc comes from a Scroller with Config.UseNumber. Parsed as float64,
+// integers above 2^53 are already rounded.
+func Decode(doc *gabs.Container, v interface{}) error {
+	b, err := json.Marshal(doc.Data())
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// Decoder decodes the documents of pages into slices of the caller's type.
+// The zero value fails on the first document that can't be decoded.
+type Decoder struct {
+	// IDField is the field holding the id the errors name documents by,
+	// "id" when empty.
+	IDField string
+	// Skip drops the documents that can't be decoded instead of failing.
+	Skip bool
+	// OnSkip, when set, is called with the error of every document dropped.
+	OnSkip func(err *DecodeError)
+}
+
+// Decode decodes docs into the slice out points to, replacing what it held.
+// Its elements can be of any type Decode takes a pointer to, usually a
+// struct or a pointer to one. A document that can't be decoded fails it
+// with a *DecodeError, and out holds the documents before it, unless Skip
+// is set.
+func (d *Decoder) Decode(docs []*gabs.Container, out interface{}) error {
+	slice := reflect.ValueOf(out)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return errors.New("ds: Decode needs a pointer to a slice")
+	}
+	slice = slice.Elem()
+	slice.SetLen(0)
+	elem := slice.Type().Elem()
+
+	field := d.IDField
+	if field == "" {
+		field = "id"
+	}
+	for i, doc := range docs {
+		v := reflect.New(elem)
+		if err := Decode(doc, v.Interface()); err != nil {
+			id, _ := documentID(doc, field)
+			derr := &DecodeError{Index: i, ID: id, Err: err}
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				derr.Path = typeErr.Field
+			}
+			if !d.Skip {
+				return derr
+			}
+			if d.OnSkip != nil {
+				d.OnSkip(derr)
+			}
+			continue
+		}
+		slice.Set(reflect.Append(slice, v.Elem()))
+	}
+	return nil
+}
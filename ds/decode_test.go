@@ -0,0 +1,120 @@
+package ds_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Jeffail/gabs"
+	"github.com/aranajuanm/dsScroller/ds"
+)
+
+type movement struct {
+	ID     int64       `json:"id"`
+	Amount json.Number `json:"amount"`
+	Status string      `json:"status"`
+	User   user        `json:"user"`
+}
+
+type user struct {
+	ID int64 `json:"id"`
+}
+
+// parse parses a document as a Scroller with Config.UseNumber does.
+func parse(t *testing.T, doc string) *gabs.Container {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(doc)))
+	decoder.UseNumber()
+	c, err := gabs.ParseJSONDecoder(decoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDecode(t *testing.T) {
+	for _, c := range []struct {
+		doc  string
+		want movement
+		// id and path are the ID and Path of the *ds.DecodeError, when the
+		// document doesn't fit.
+		id, path string
+	}{
+		{
+			doc:  `{"id":9007199254740993,"amount":1234.50,"status":"ok","user":{"id":12345678901234567}}`,
+			want: movement{ID: 9007199254740993, Amount: "1234.50", Status: "ok", User: user{ID: 12345678901234567}},
+		},
+		{
+			// Decimals keep their digits into json.Number fields.
+			doc:  `{"id":1,"amount":0.1000000000000000055511151231257827}`,
+			want: movement{ID: 1, Amount: "0.1000000000000000055511151231257827"},
+		},
+		{
+			// Fields missing from either side are left alone.
+			doc:  `{"id":2,"extra":[1,2]}`,
+			want: movement{ID: 2},
+		},
+		{doc: `{"id":3,"user":[12]}`, id: "3", path: "user"},
+		{doc: `{"id":4,"status":200}`, id: "4", path: "status"},
+		{doc: `{"id":5,"user":{"id":"seller"}}`, id: "5", path: "user.id"},
+		{doc: `{"id":18446744073709551616}`, id: "18446744073709551616", path: "id"},
+		{doc: `{"amount":1,"status":false}`, path: "status"},
+	} {
+		var got []movement
+		err := (&ds.Decoder{}).Decode([]*gabs.Container{parse(t, c.doc)}, &got)
+		if c.path == "" {
+			if err != nil {
+				t.Errorf("Decode(%s): %v", c.doc, err)
+			} else if len(got) != 1 || !reflect.DeepEqual(got[0], c.want) {
+				t.Errorf("Decode(%s) = %+v, want %+v", c.doc, got, c.want)
+			}
+			continue
+		}
+		var de *ds.DecodeError
+		if !errors.As(err, &de) {
+			t.Errorf("Decode(%s) = %v, want a *ds.DecodeError", c.doc, err)
+		} else if de.Index != 0 || de.ID != c.id || de.Path != c.path {
+			t.Errorf("Decode(%s) fails on document %d, id %q at %q, want 0, %q at %q", c.doc, de.Index, de.ID, de.Path, c.id, c.path)
+		}
+	}
+}
+
+func TestDecoderSkip(t *testing.T) {
+	docs := []*gabs.Container{
+		parse(t, `{"id":1,"status":"ok"}`),
+		parse(t, `{"id":2,"status":7}`),
+		parse(t, `{"id":3,"status":"ok"}`),
+		parse(t, `{"uuid":"a4","amount":true}`),
+	}
+
+	var got []movement
+	err := (&ds.Decoder{}).Decode(docs, &got)
+	var de *ds.DecodeError
+	if !errors.As(err, &de) || de.Index != 1 || de.ID != "2" {
+		t.Fatalf("Decode = %v, want a *ds.DecodeError on document 1", err)
+	}
+	if len(got) != 1 || got[0].ID != 1 {
+		t.Errorf("failed Decode left %+v, want the document before the error", got)
+	}
+
+	var skipped []*ds.DecodeError
+	d := &ds.Decoder{
+		IDField: "uuid",
+		Skip:    true,
+		OnSkip:  func(err *ds.DecodeError) { skipped = append(skipped, err) },
+	}
+	if err := d.Decode(docs, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].ID != 1 || got[1].ID != 3 {
+		t.Errorf("Decode with Skip = %+v, want the documents 1 and 3", got)
+	}
+	if len(skipped) != 2 || skipped[0].Index != 1 || skipped[0].ID != "" || skipped[1].Index != 3 || skipped[1].ID != "a4" {
+		t.Errorf("skipped %v, want documents 1 and 3, with the uuid of the last", skipped)
+	}
+
+	if err := d.Decode(docs, got); err == nil {
+		t.Error("Decode into a slice, not a pointer to one, succeeded")
+	}
+}
@@ -0,0 +1,238 @@
+package ds
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/Jeffail/gabs"
+)
+
+// IDSet remembers the ids of the documents returned, so that a document
+// returned twice is dropped the second time. Implementations are safe for
+// concurrent use, so that the scrollers of one export can share one.
+type IDSet interface {
+	// Add adds id and tells whether it was not in the set already.
+	Add(id string) bool
+}
+
+// documentID is the id of doc in field, as a string, false when the field
+// is missing.
+func documentID(doc *gabs.Container, field string) (string, bool) {
+	switch v := doc.Path(field).Data().(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case json.Number:
+		return v.String(), true
+	default:
+		return doc.Path(field).String(), true
+	}
+}
+
+// MemorySet is an IDSet holding the last max ids added. Older ones are
+// forgotten, so a document repeated after that many others is not caught.
+type MemorySet struct {
+	mu   sync.Mutex
+	ids  map[string]struct{}
+	ring []string
+	next int
+}
+
+// NewMemorySet returns a MemorySet of at most max ids.
+func NewMemorySet(max int) *MemorySet {
+	if max <= 0 {
+		max = 1
+	}
+	return &MemorySet{ids: make(map[string]struct{}), ring: make([]string, 0, max)}
+}
+
+func (m *MemorySet) Add(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.ids[id]; ok {
+		return false
+	}
+	if len(m.ring) < cap(m.ring) {
+		m.ring = append(m.ring, id)
+	} else {
+		delete(m.ids, m.ring[m.next])
+		m.ring[m.next] = id
+		m.next = (m.next + 1) % len(m.ring)
+	}
+	m.ids[id] = struct{}{}
+	return true
+}
+
+// bloomMagic starts the header of a bloom filter file, followed by the
+// number of bits and of hashes.
+const bloomMagic = "dsbloom1"
+
+const bloomHeader = len(bloomMagic) + 16
+
+// BloomFilter is an IDSet kept in a file, of a fixed size whatever the
+// number of ids. It can tell that an id is new when it is not, at the rate
+// it was sized for, so that many documents may be dropped by mistake.
+//
+// Ids are added in memory; Sync writes the bytes that changed to the file,
+// so that the filter can be reopened to continue an export.
+type BloomFilter struct {
+	mu     sync.Mutex
+	file   *os.File
+	bits   []byte
+	m      uint64
+	k      uint64
+	dirty  map[int]struct{}
+	loaded bool
+}
+
+// OpenBloomFilter opens the filter in path, or creates one sized for
+// capacity ids with a false positive rate of fpRate if there is none.
+func OpenBloomFilter(path string, capacity int, fpRate float64) (*BloomFilter, error) {
+	if capacity <= 0 || fpRate <= 0 || fpRate >= 1 {
+		return nil, errors.New("bloom filter needs a positive capacity and a rate between 0 and 1")
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	b := &BloomFilter{file: file, dirty: make(map[int]struct{})}
+
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if fi.Size() > 0 {
+		if err := b.load(fi.Size()); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return b, nil
+	}
+
+	// m = -n ln(p) / ln(2)^2 bits and k = m/n ln(2) hashes.
+	b.m = uint64(math.Ceil(-float64(capacity) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	b.m = (b.m + 7) / 8 * 8
+	b.k = uint64(math.Max(1, math.Round(float64(b.m)/float64(capacity)*math.Ln2)))
+	b.bits = make([]byte, b.m/8)
+
+	header := make([]byte, bloomHeader)
+	copy(header, bloomMagic)
+	binary.BigEndian.PutUint64(header[len(bloomMagic):], b.m)
+	binary.BigEndian.PutUint64(header[len(bloomMagic)+8:], b.k)
+	if _, err := file.Write(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Truncate(int64(bloomHeader) + int64(len(b.bits))); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *BloomFilter) load(size int64) error {
+	header := make([]byte, bloomHeader)
+	if _, err := io.ReadFull(b.file, header); err != nil || string(header[:len(bloomMagic)]) != bloomMagic {
+		return errors.New("not a bloom filter file")
+	}
+	b.m = binary.BigEndian.Uint64(header[len(bloomMagic):])
+	b.k = binary.BigEndian.Uint64(header[len(bloomMagic)+8:])
+	if b.m == 0 || b.k == 0 || int64(bloomHeader)+int64(b.m/8) != size {
+		return errors.New("corrupt bloom filter")
+	}
+	b.bits = make([]byte, b.m/8)
+	if _, err := io.ReadFull(b.file, b.bits); err != nil {
+		return err
+	}
+	b.loaded = true
+	return nil
+}
+
+// Loaded tells whether the filter was read from an existing file.
+func (b *BloomFilter) Loaded() bool {
+	return b.loaded
+}
+
+func (b *BloomFilter) Add(id string) bool {
+	// Double hashing: the i-th bit is h1 + i*h2.
+	h := fnv.New128a()
+	h.Write([]byte(id))
+	sum := h.Sum(nil)
+	h1 := binary.BigEndian.Uint64(sum[:8])
+	h2 := binary.BigEndian.Uint64(sum[8:]) | 1
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	added := false
+	for i := uint64(0); i < b.k; i++ {
+		bit := (h1 + i*h2) % b.m
+		byteIndex, mask := int(bit/8), byte(1)<<(bit%8)
+		if b.bits[byteIndex]&mask == 0 {
+			b.bits[byteIndex] |= mask
+			b.dirty[byteIndex] = struct{}{}
+			added = true
+		}
+	}
+	return added
+}
+
+// Sync writes the ids added since the last Sync to the file.
+func (b *BloomFilter) Sync() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.dirty) == 0 {
+		return nil
+	}
+
+	indexes := make([]int, 0, len(b.dirty))
+	for i := range b.dirty {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	// Changed bytes close to each other are written together.
+	for start := 0; start < len(indexes); {
+		end := start
+		for end+1 < len(indexes) && indexes[end+1]-indexes[end] <= 64 {
+			end++
+		}
+		from, to := indexes[start], indexes[end]+1
+		if _, err := b.file.WriteAt(b.bits[from:to], int64(bloomHeader+from)); err != nil {
+			return err
+		}
+		start = end + 1
+	}
+	b.dirty = make(map[int]struct{})
+	return b.file.Sync()
+}
+
+// Close syncs and closes the file.
+func (b *BloomFilter) Close() error {
+	if err := b.Sync(); err != nil {
+		b.file.Close()
+		return err
+	}
+	return b.file.Close()
+}
+
+// Discard closes the file without syncing it, so that the ids added since
+// the last Sync are not in the filter when it is opened again. An export
+// that failed before writing the page of those ids asks for them again.
+func (b *BloomFilter) Discard() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dirty = make(map[int]struct{})
+	return b.file.Close()
+}
@@ -0,0 +1,181 @@
+package ds_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/Jeffail/gabs"
+	"github.com/aranajuanm/dsScroller/ds"
+	"github.com/aranajuanm/dsScroller/ds/dstest"
+)
+
+func TestMemorySet(t *testing.T) {
+	set := ds.NewMemorySet(2)
+	for i, c := range []struct {
+		id  string
+		new bool
+	}{
+		{"1", true},
+		{"2", true},
+		{"1", false},
+		{"3", true},
+		// 1 was forgotten to make room for 3.
+		{"1", true},
+		{"3", false},
+		{"2", true},
+	} {
+		if got := set.Add(c.id); got != c.new {
+			t.Errorf("Add %d of %s = %v, want %v", i, c.id, got, c.new)
+		}
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dsbloom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ids")
+
+	b, err := ds.OpenBloomFilter(path, 2000, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Loaded() {
+		t.Error("a new filter says it was loaded")
+	}
+	for i := 0; i < 1000; i++ {
+		if !b.Add(strconv.Itoa(i)) {
+			t.Errorf("id %d is not new the first time", i)
+		}
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = ds.OpenBloomFilter(path, 2000, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.Loaded() {
+		t.Error("a reopened filter says it was not loaded")
+	}
+	for i := 0; i < 1000; i++ {
+		if b.Add(strconv.Itoa(i)) {
+			t.Errorf("id %d is new again after reopening", i)
+		}
+	}
+	// Sized for 2000 ids with 1% of false positives, 1000 new ids can't
+	// lose more than a few dozen.
+	positives := 0
+	for i := 1000; i < 2000; i++ {
+		if !b.Add(strconv.Itoa(i)) {
+			positives++
+		}
+	}
+	if positives > 30 {
+		t.Errorf("%d false positives out of 1000 new ids, want about 10", positives)
+	}
+	if err := b.Discard(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The ids of the discarded filter were never synced.
+	b, err = ds.OpenBloomFilter(path, 2000, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	discarded := 0
+	for i := 1000; i < 2000; i++ {
+		if b.Add(strconv.Itoa(i)) {
+			discarded++
+		}
+	}
+	if discarded < 1000-positives-30 {
+		t.Errorf("%d of the 1000 discarded ids are new again, want nearly all", discarded)
+	}
+}
+
+func TestOpenBloomFilterErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dsbloom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, c := range []struct {
+		name     string
+		content  string
+		capacity int
+		fpRate   float64
+	}{
+		{"no capacity", "", 0, 0.01},
+		{"no rate", "", 100, 0},
+		{"rate of 1", "", 100, 1},
+		{"not a filter", "id,amount\n1,2\n", 100, 0.01},
+		{"truncated", "dsbloom1\x00\x00\x00\x00\x00\x00\x04\x00\x00\x00\x00\x00\x00\x00\x00\x07", 100, 0.01},
+	} {
+		path := filepath.Join(dir, c.name)
+		if c.content != "" {
+			if err := ioutil.WriteFile(path, []byte(c.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if b, err := ds.OpenBloomFilter(path, c.capacity, c.fpRate); err == nil {
+			b.Close()
+			t.Errorf("%s: OpenBloomFilter succeeded, want an error", c.name)
+		}
+	}
+}
+
+func TestScrollSeen(t *testing.T) {
+	srv := dstest.NewServer(dstest.Documents(25))
+	defer srv.Close()
+	seen := ds.NewMemorySet(100)
+	for _, id := range []string{"1", "2", "3", "4", "5", "12"} {
+		seen.Add(id)
+	}
+
+	var dropped []string
+	s := newScroller(t, srv, 10, ds.Config{
+		Seen:      seen,
+		OnDropped: func(doc *gabs.Container) { dropped = append(dropped, doc.Path("id").String()) },
+	})
+	ids, err := scrollAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 19 || ids[0] != 6 || ids[5] != 11 || ids[6] != 13 {
+		t.Errorf("got ids %v, want 6 to 25 but 12", ids)
+	}
+	if len(dropped) != 6 || s.Dropped() != 6 {
+		t.Errorf("dropped %v and Dropped() = %d, want the 6 ids seen before", dropped, s.Dropped())
+	}
+
+	// The pages of a second scroll sharing the set only hold documents
+	// seen before, so they are dropped whole.
+	s = newScroller(t, srv, 10, ds.Config{Seen: seen})
+	ids, err = scrollAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 || s.Duplicates() != 3 || s.Dropped() != 0 {
+		t.Errorf("second scroll returned %d documents, Duplicates() = %d and Dropped() = %d, want 0, 3 and 0",
+			len(ids), s.Duplicates(), s.Dropped())
+	}
+
+	// Nor is a page of documents seen before that DS keeps answering
+	// asked for forever.
+	srv.Repeat(2, 100)
+	s = newScroller(t, srv, 10, ds.Config{Seen: seen})
+	var repeated *ds.RepeatedPageError
+	if _, err := scrollAll(s); !errors.As(err, &repeated) {
+		t.Errorf("scroll of a repeated page seen before = %v, want a *ds.RepeatedPageError", err)
+	}
+}
@@ -0,0 +1,205 @@
+package dstest
+
+import (
+	"encoding/json"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/Jeffail/gabs"
+)
+
+// filter returns the documents matching query. It understands and, or,
+// not, eq, in, exists, range and date_range, enough for the queries the
+// scroller sends; any other clause matches every document.
+func filter(docs []map[string]interface{}, query *gabs.Container) []map[string]interface{} {
+	var matched []map[string]interface{}
+	for _, doc := range docs {
+		if query.Data() == nil || match(doc, query) {
+			matched = append(matched, doc)
+		}
+	}
+	return matched
+}
+
+func match(doc map[string]interface{}, clause *gabs.Container) bool {
+	clauses, _ := clause.ChildrenMap()
+	for op, c := range clauses {
+		if !matchClause(doc, op, c) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchClause(doc map[string]interface{}, op string, c *gabs.Container) bool {
+	switch op {
+	case "and", "or":
+		children, _ := c.Children()
+		for _, child := range children {
+			if m := match(doc, child); m != (op == "and") {
+				return m
+			}
+		}
+		return op == "and"
+	case "not":
+		return !match(doc, c)
+	case "eq":
+		return compare(field(doc, c), c.Path("value").Data()) == 0
+	case "in":
+		values, _ := c.S("values").Children()
+		for _, v := range values {
+			if compare(field(doc, c), v.Data()) == 0 {
+				return true
+			}
+		}
+		return false
+	case "exists":
+		return field(doc, c) != nil
+	case "range", "date_range":
+		v := field(doc, c)
+		if v == nil {
+			return false
+		}
+		bounds := map[string]func(int) bool{
+			"gt":  func(n int) bool { return n > 0 },
+			"gte": func(n int) bool { return n >= 0 },
+			"lt":  func(n int) bool { return n < 0 },
+			"lte": func(n int) bool { return n <= 0 },
+		}
+		for name, ok := range bounds {
+			if b := c.Path(name).Data(); b != nil && !ok(compare(v, b)) {
+				return false
+			}
+		}
+		return true
+	}
+	return true
+}
+
+// field is the value in doc of the "field" of a clause, nil when missing.
+func field(doc map[string]interface{}, clause *gabs.Container) interface{} {
+	path, _ := clause.Path("field").Data().(string)
+	return lookup(doc, path)
+}
+
+func lookup(doc map[string]interface{}, path string) interface{} {
+	var v interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+// compare orders numbers as numbers and anything else as strings, which
+// also orders the dates of the documents against date-only bounds.
+func compare(a interface{}, b interface{}) int {
+	na, aok := number(a)
+	nb, bok := number(b)
+	if aok && bok {
+		return na.Cmp(nb)
+	}
+	return strings.Compare(toString(a), toString(b))
+}
+
+// number is v as an exact number, so that ids above 2^53 given as int64 or
+// json.Number are told apart.
+func number(v interface{}) (*big.Float, bool) {
+	switch n := v.(type) {
+	case float64:
+		return new(big.Float).SetFloat64(n), true
+	case int:
+		return new(big.Float).SetInt64(int64(n)), true
+	case int64:
+		return new(big.Float).SetInt64(n), true
+	case json.Number:
+		f, _, err := big.ParseFloat(string(n), 10, 128, big.ToNearestEven)
+		return f, err == nil
+	}
+	return nil, false
+}
+
+// integer is v as an int, for the sizes and slices of requests.
+func integer(v interface{}) (int, bool) {
+	n, ok := number(v)
+	if !ok {
+		return 0, false
+	}
+	i, _ := n.Int64()
+	return int(i), true
+}
+
+func toString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// sliceDocs keeps the documents of the "slice" of request, every max-th
+// one starting at id.
+func sliceDocs(docs []map[string]interface{}, request *gabs.Container) []map[string]interface{} {
+	id, ok := integer(request.Path("slice.id").Data())
+	max, _ := integer(request.Path("slice.max").Data())
+	if !ok || max <= 0 {
+		return docs
+	}
+	var sliced []map[string]interface{}
+	for i, doc := range docs {
+		if i%max == id {
+			sliced = append(sliced, doc)
+		}
+	}
+	return sliced
+}
+
+// sortDocs orders docs by the first "sort" of request, if any.
+func sortDocs(docs []map[string]interface{}, request *gabs.Container) {
+	key := request.S("sort")
+	if _, ok := key.Data().([]interface{}); ok {
+		key = key.Index(0)
+	}
+	path, _ := key.Path("field").Data().(string)
+	if path == "" {
+		return
+	}
+	desc := key.Path("order").Data() == "desc"
+	sort.SliceStable(docs, func(i, j int) bool {
+		n := compare(lookup(docs[i], path), lookup(docs[j], path))
+		if desc {
+			return n > 0
+		}
+		return n < 0
+	})
+}
+
+// projections are the fields requested, none meaning whole documents.
+func projections(request *gabs.Container) []string {
+	var fields []string
+	children, _ := request.S("projections").Children()
+	for _, c := range children {
+		if f, ok := c.Data().(string); ok {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// project keeps the fields of doc, whole when there are none.
+func project(doc map[string]interface{}, fields []string) interface{} {
+	if len(fields) == 0 {
+		return doc
+	}
+	projected := gabs.New()
+	for _, f := range fields {
+		if v := lookup(doc, f); v != nil {
+			projected.SetP(v, f)
+		}
+	}
+	return projected.Data()
+}
@@ -0,0 +1,294 @@
+// Package dstest provides a fake DS search service to run scrolls against
+// without the read proxy, in the spirit of net/http/httptest.
+//
+//	srv := dstest.NewServer(dstest.Documents(1000))
+//	defer srv.Close()
+//	srv.Throttle(2, 3)
+//	srv.Expire(5)
+//	s := ds.NewScroller(ds.Config{URL: srv.URL, Token: "t"}, request)
+package dstest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/gabs"
+)
+
+// DefaultPageSize is the size of the pages of a scroll that asks for none.
+const DefaultPageSize = 10
+
+// Server is a fake DS search service. It answers scroll requests over its
+// documents, filtered with the "query" and ordered by the "sort" of the
+// first request, and injects the faults it was told to.
+//
+// Pages are numbered from 1 within every scroll: page 1 answers the request
+// without scroll_id, page 2 the first one with it, and so on. Faults apply
+// to that page of any scroll.
+type Server struct {
+	// URL of the search endpoint.
+	URL string
+	// Token, when set, is the only x-auth-token accepted, others get 401.
+	Token string
+	// Latency is waited before answering every request.
+	Latency time.Duration
+
+	server *httptest.Server
+
+	mu       sync.Mutex
+	docs     []map[string]interface{}
+	faults   map[int][]*fault
+	scrolls  map[string]*scroll
+	next     int
+	requests []*gabs.Container
+}
+
+type fault struct {
+	status     int
+	body       string
+	times      int
+	retryAfter string
+	// repeat answers the page before again instead of failing.
+	repeat bool
+}
+
+// scroll is the state of an open scroll.
+type scroll struct {
+	id     int
+	docs   []map[string]interface{}
+	size   int
+	offset int
+	page   int
+	fields []string
+	// last is the page answered last, for Repeat.
+	last []interface{}
+}
+
+// NewServer starts a Server over docs on a local port. Close it when done.
+func NewServer(docs []map[string]interface{}) *Server {
+	s := NewHandler(docs)
+	s.server = httptest.NewServer(s)
+	s.URL = s.server.URL + "/search"
+	return s
+}
+
+// NewHandler returns a Server over docs that is not listening, to serve it
+// with http.ListenAndServe or a mux. URL is left empty.
+func NewHandler(docs []map[string]interface{}) *Server {
+	return &Server{
+		docs:    docs,
+		faults:  make(map[int][]*fault),
+		scrolls: make(map[string]*scroll),
+	}
+}
+
+// Documents generates n documents with an increasing id, an amount, a
+// status, a nested user and a date_created one hour apart.
+func Documents(n int) []map[string]interface{} {
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.FixedZone("", -4*3600))
+	statuses := []string{"available", "unavailable", "released"}
+	docs := make([]map[string]interface{}, n)
+	for i := range docs {
+		docs[i] = map[string]interface{}{
+			"id":           float64(i + 1),
+			"amount":       float64(i%100) * 1.5,
+			"status":       statuses[i%len(statuses)],
+			"user":         map[string]interface{}{"id": float64(i % 7), "name": fmt.Sprintf("user %d", i%7)},
+			"date_created": start.Add(time.Duration(i) * time.Hour).Format("2006-01-02T15:04:05.000-07:00"),
+		}
+	}
+	return docs
+}
+
+// Close shuts down a server started by NewServer.
+func (s *Server) Close() {
+	if s.server != nil {
+		s.server.Close()
+	}
+}
+
+// Fail makes the next times requests for page answer status.
+func (s *Server) Fail(page int, status int, times int) {
+	s.addFault(page, &fault{status: status, times: times, body: fmt.Sprintf(`{"message":"injected %d","status":%d}`, status, status)})
+}
+
+// Throttle makes the next times requests for page answer 429, with a
+// Retry-After of 1 second.
+func (s *Server) Throttle(page int, times int) {
+	s.addFault(page, &fault{status: http.StatusTooManyRequests, times: times, retryAfter: "1", body: `{"message":"too many requests","status":429}`})
+}
+
+// Repeat makes the next times requests for page get the documents of the
+// page before again, as a scroll that was asked twice for the same page
+// would.
+func (s *Server) Repeat(page int, times int) {
+	s.addFault(page, &fault{status: http.StatusOK, times: times, repeat: true})
+}
+
+// Expire makes the next request for page find its scroll expired: it is
+// answered 404 and the scroll is closed.
+func (s *Server) Expire(page int) {
+	s.addFault(page, &fault{status: http.StatusNotFound, times: 1, body: `{"message":"scroll not found","status":404}`})
+}
+
+func (s *Server) addFault(page int, f *fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[page] = append(s.faults[page], f)
+}
+
+// Requests returns the bodies of the requests received so far.
+func (s *Server) Requests() []*gabs.Container {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*gabs.Container(nil), s.requests...)
+}
+
+// ServeHTTP answers a DS search request.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Latency > 0 {
+		time.Sleep(s.Latency)
+	}
+	if r.Method != http.MethodPost {
+		reply(w, http.StatusMethodNotAllowed, map[string]interface{}{"message": "use POST"})
+		return
+	}
+	if s.Token != "" && r.Header.Get("x-auth-token") != s.Token {
+		reply(w, http.StatusUnauthorized, map[string]interface{}{"message": "invalid token"})
+		return
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		reply(w, http.StatusBadRequest, map[string]interface{}{"message": err.Error()})
+		return
+	}
+	// Numbers are kept as json.Number, as a data source would keep the
+	// digits of the ids above 2^53 that the scroller sends.
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	request, err := gabs.ParseJSONDecoder(decoder)
+	if err != nil {
+		reply(w, http.StatusBadRequest, map[string]interface{}{"message": "invalid json: " + err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, request)
+
+	scrollID, _ := request.Path("scroll_id").Data().(string)
+	if scrollID == "" {
+		s.start(w, request)
+		return
+	}
+	sc, ok := s.scrolls[scrollID]
+	if !ok {
+		reply(w, http.StatusNotFound, map[string]interface{}{"message": "scroll not found", "status": 404})
+		return
+	}
+	// A failed request leaves the scroll where it was, to be asked again
+	// with the same scroll_id, unless it expired.
+	if f := s.inject(w, sc.page+1); f != nil {
+		if f.repeat {
+			s.repeat(w, sc)
+		}
+		if f.status == http.StatusNotFound {
+			delete(s.scrolls, scrollID)
+		}
+		return
+	}
+	delete(s.scrolls, scrollID)
+	s.page(w, sc)
+}
+
+// start opens the scroll of a request without scroll_id and answers its
+// first page. Requests of type other than scroll, or of size 0, get all
+// their matches counted and none returned.
+func (s *Server) start(w http.ResponseWriter, request *gabs.Container) {
+	if f := s.inject(w, 1); f != nil && !f.repeat {
+		return
+	}
+
+	docs := filter(s.docs, request.S("query"))
+	docs = sliceDocs(docs, request)
+	sortDocs(docs, request)
+
+	size := DefaultPageSize
+	if n, ok := integer(request.Path("size").Data()); ok {
+		size = n
+	}
+	if typ, _ := request.Path("type").Data().(string); typ != "scroll" || size <= 0 {
+		reply(w, http.StatusOK, map[string]interface{}{"documents": []interface{}{}, "paging": map[string]interface{}{"total": len(docs)}})
+		return
+	}
+
+	s.next++
+	sc := &scroll{id: s.next, docs: docs, size: size}
+	sc.fields = projections(request)
+	s.page(w, sc)
+}
+
+// page answers the next page of sc and registers its new scroll_id.
+func (s *Server) page(w http.ResponseWriter, sc *scroll) {
+	end := sc.offset + sc.size
+	if end > len(sc.docs) {
+		end = len(sc.docs)
+	}
+	page := make([]interface{}, 0, end-sc.offset)
+	for _, doc := range sc.docs[sc.offset:end] {
+		page = append(page, project(doc, sc.fields))
+	}
+	sc.offset = end
+	sc.page++
+	sc.last = page
+	s.reply(w, sc, page)
+}
+
+// repeat answers the last page of sc again, under a new scroll_id.
+func (s *Server) repeat(w http.ResponseWriter, sc *scroll) {
+	s.reply(w, sc, sc.last)
+}
+
+func (s *Server) reply(w http.ResponseWriter, sc *scroll, page []interface{}) {
+	s.next++
+	id := fmt.Sprintf("scroll-%d-%d", sc.id, s.next)
+	s.scrolls[id] = sc
+	reply(w, http.StatusOK, map[string]interface{}{
+		"scroll_id": id,
+		"documents": page,
+		"paging":    map[string]interface{}{"total": len(sc.docs)},
+	})
+}
+
+// inject answers with the first pending fault of page, if any.
+func (s *Server) inject(w http.ResponseWriter, page int) *fault {
+	for _, f := range s.faults[page] {
+		if f.times <= 0 {
+			continue
+		}
+		f.times--
+		if f.repeat {
+			return f
+		}
+		if f.retryAfter != "" {
+			w.Header().Set("Retry-After", f.retryAfter)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(f.status)
+		w.Write([]byte(f.body))
+		return f
+	}
+	return nil
+}
+
+func reply(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,103 @@
+package ds
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// TransportError is returned when a scroll request got no HTTP response at
+// all: connection refused, timeouts, broken connections...
+type TransportError struct {
+	// ScrollID is the scroll_id that was being followed, empty on the first page.
+	ScrollID string
+	Err      error
+}
+
+func (e *TransportError) Error() string {
+	if e.ScrollID == "" {
+		return fmt.Sprintf("scroll request failed: %v", e.Err)
+	}
+	return fmt.Sprintf("scroll request failed (last scroll_id %s): %v", e.ScrollID, e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// StatusError is returned when DS answers a scroll request with anything
+// but 200 OK.
+type StatusError struct {
+	StatusCode int
+	Body       string
+	ScrollID   string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("DS answered %d: %s", e.StatusCode, e.Body)
+}
+
+// AuthError is returned when DS rejects the token with 401 or 403.
+type AuthError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *AuthError) Error() string {
+	if e.StatusCode == http.StatusForbidden {
+		return fmt.Sprintf("DS answered 403, the token is not allowed to search this index: %s", e.Body)
+	}
+	return fmt.Sprintf("DS answered 401, the token is missing, expired or invalid: %s", e.Body)
+}
+
+// MalformedResponseError is returned when a 200 OK response is not the JSON
+// page DS is expected to send.
+type MalformedResponseError struct {
+	Body string
+	Err  error
+}
+
+func (e *MalformedResponseError) Error() string {
+	return fmt.Sprintf("malformed DS response: %v", e.Err)
+}
+
+func (e *MalformedResponseError) Unwrap() error {
+	return e.Err
+}
+
+// ScrollExpiredError is returned when DS no longer knows the scroll_id being
+// followed, so the scroll has to be started again.
+type ScrollExpiredError struct {
+	ScrollID string
+}
+
+func (e *ScrollExpiredError) Error() string {
+	return fmt.Sprintf("scroll %s expired", e.ScrollID)
+}
+
+// RepeatedPageError is returned when DS answers the same page again too many
+// times in a row, as a scroll stuck on it would, dropped every time.
+type RepeatedPageError struct {
+	ScrollID string
+	// Pages is how many times in a row the page was repeated.
+	Pages int
+}
+
+func (e *RepeatedPageError) Error() string {
+	return fmt.Sprintf("scroll %s repeated the page before %d times in a row", e.ScrollID, e.Pages)
+}
+
+// CallbackError is returned by ForEach when its callback fails. The scroll
+// stops at that page.
+type CallbackError struct {
+	// Page is the 1-based number of the page the callback failed on.
+	Page int
+	Err  error
+}
+
+func (e *CallbackError) Error() string {
+	return fmt.Sprintf("page %d: %v", e.Page, e.Err)
+}
+
+func (e *CallbackError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,135 @@
+package ds
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mercadolibre/go-meli-toolkit/golimiter"
+)
+
+const (
+	// healthyStreak is how many fast, successful responses in a row it takes
+	// to speed up again.
+	healthyStreak = 10
+	// slowFactor is how much slower than the best latency seen a response
+	// must be to count as the proxy struggling.
+	slowFactor = 2
+	// latencyAlpha weighs the last response in the latency moving average.
+	latencyAlpha = 0.2
+)
+
+// Pacer spreads the requests of all the scrollers sharing it over a
+// requests per minute budget enforced with a golimiter.Limiter. It halves
+// the rate on 429/503 and when latency rises, and grows it back towards the
+// budget while the read proxy is healthy. A nil *Pacer does not pace.
+type Pacer struct {
+	// OnChange, when set, is called with every rate change and its reason.
+	OnChange func(from uint64, to uint64, reason string)
+
+	mu      sync.Mutex
+	limiter *golimiter.Limiter
+	max     uint64
+	min     uint64
+	rpm     uint64
+
+	latency  time.Duration
+	baseline time.Duration
+	healthy  int
+	cooldown int
+}
+
+// NewPacer returns a Pacer with a budget of rpm requests per minute.
+func NewPacer(rpm uint64) *Pacer {
+	min := rpm / 20
+	if min == 0 {
+		min = 1
+	}
+	p := &Pacer{max: rpm, min: min}
+	p.setRate(rpm, "")
+	return p
+}
+
+// Wait blocks until the budget allows one more request.
+func (p *Pacer) Wait() {
+	if p == nil {
+		return
+	}
+	for {
+		p.mu.Lock()
+		limiter, rpm := p.limiter, p.rpm
+		p.mu.Unlock()
+
+		if _, err := limiter.Action(1, func() (interface{}, error) { return nil, nil }); err == nil {
+			return
+		}
+		time.Sleep(time.Minute / time.Duration(rpm))
+	}
+}
+
+// Observe adapts the rate to the outcome of a request. status is 0 when the
+// request got no response.
+func (p *Pacer) Observe(status int, latency time.Duration) {
+	if p == nil || status == 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if isThrottled(status) {
+		p.healthy = 0
+		p.setRate(p.rpm/2, fmt.Sprintf("got %d", status))
+		return
+	}
+
+	if p.latency == 0 {
+		p.latency = latency
+	} else {
+		p.latency = time.Duration(latencyAlpha*float64(latency) + (1-latencyAlpha)*float64(p.latency))
+	}
+	if p.baseline == 0 || p.latency < p.baseline {
+		p.baseline = p.latency
+	}
+
+	if p.cooldown > 0 {
+		p.cooldown--
+	}
+	if p.latency > slowFactor*p.baseline {
+		p.healthy = 0
+		if p.cooldown == 0 {
+			p.cooldown = healthyStreak
+			p.setRate(p.rpm*3/4, fmt.Sprintf("latency up to %v", p.latency.Round(time.Millisecond)))
+		}
+		return
+	}
+
+	p.healthy++
+	if p.healthy >= healthyStreak && p.rpm < p.max {
+		p.healthy = 0
+		p.setRate(p.rpm+p.max/10+1, "healthy")
+	}
+}
+
+// setRate replaces the limiter, golimiter has no way to change the rate of
+// an existing one. Callers hold p.mu, except NewPacer.
+func (p *Pacer) setRate(rpm uint64, reason string) {
+	if rpm < p.min {
+		rpm = p.min
+	}
+	if rpm > p.max {
+		rpm = p.max
+	}
+	if rpm == p.rpm {
+		return
+	}
+	if reason != "" && p.OnChange != nil {
+		p.OnChange(p.rpm, rpm, reason)
+	}
+	p.rpm = rpm
+	p.limiter = golimiter.New(rpm, time.Second)
+}
+
+func isThrottled(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
@@ -0,0 +1,565 @@
+package ds
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/Jeffail/gabs"
+)
+
+// Clause is a clause of the DS query DSL, built with And, Or, Not, Eq, In,
+// Exists, Range and DateRange.
+type Clause interface {
+	// body is the JSON the clause serializes to, once valid.
+	body() map[string]interface{}
+	validate() error
+}
+
+// QueryError tells which clause of a query is malformed. Path is where it
+// is in the body, e.g. query.and[1].eq.
+type QueryError struct {
+	Path string
+	Err  error
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// nest prefixes the path of a QueryError with the clause holding it.
+func nest(path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if qe, ok := err.(*QueryError); ok {
+		return &QueryError{Path: path + "." + qe.Path, Err: qe.Err}
+	}
+	return &QueryError{Path: path, Err: err}
+}
+
+type boolClause struct {
+	op      string
+	clauses []Clause
+}
+
+// And matches the documents matching every clause.
+func And(clauses ...Clause) Clause {
+	return &boolClause{op: "and", clauses: clauses}
+}
+
+// Or matches the documents matching any clause.
+func Or(clauses ...Clause) Clause {
+	return &boolClause{op: "or", clauses: clauses}
+}
+
+func (c *boolClause) body() map[string]interface{} {
+	children := make([]interface{}, len(c.clauses))
+	for i, child := range c.clauses {
+		children[i] = child.body()
+	}
+	return map[string]interface{}{c.op: children}
+}
+
+func (c *boolClause) validate() error {
+	if len(c.clauses) == 0 {
+		return &QueryError{Path: c.op, Err: errors.New("needs at least one clause")}
+	}
+	for i, child := range c.clauses {
+		if child == nil {
+			return &QueryError{Path: fmt.Sprintf("%s[%d]", c.op, i), Err: errors.New("nil clause")}
+		}
+		if err := child.validate(); err != nil {
+			return nest(fmt.Sprintf("%s[%d]", c.op, i), err)
+		}
+	}
+	return nil
+}
+
+type notClause struct {
+	clause Clause
+}
+
+// Not matches the documents not matching clause.
+func Not(clause Clause) Clause {
+	return &notClause{clause: clause}
+}
+
+func (c *notClause) body() map[string]interface{} {
+	return map[string]interface{}{"not": c.clause.body()}
+}
+
+func (c *notClause) validate() error {
+	if c.clause == nil {
+		return &QueryError{Path: "not", Err: errors.New("nil clause")}
+	}
+	return nest("not", c.clause.validate())
+}
+
+type eqClause struct {
+	field string
+	value interface{}
+}
+
+// Eq matches the documents whose field is value.
+func Eq(field string, value interface{}) Clause {
+	return &eqClause{field: field, value: value}
+}
+
+func (c *eqClause) body() map[string]interface{} {
+	return map[string]interface{}{"eq": map[string]interface{}{"field": c.field, "value": c.value}}
+}
+
+func (c *eqClause) validate() error {
+	if c.field == "" {
+		return &QueryError{Path: "eq", Err: errors.New("empty field")}
+	}
+	if !scalar(c.value) {
+		return &QueryError{Path: "eq", Err: fmt.Errorf("value of %s must be a string, number or bool, got %T", c.field, c.value)}
+	}
+	return nil
+}
+
+type inClause struct {
+	field  string
+	values []interface{}
+}
+
+// In matches the documents whose field is any of values.
+func In(field string, values ...interface{}) Clause {
+	return &inClause{field: field, values: values}
+}
+
+func (c *inClause) body() map[string]interface{} {
+	return map[string]interface{}{"in": map[string]interface{}{"field": c.field, "values": c.values}}
+}
+
+func (c *inClause) validate() error {
+	if c.field == "" {
+		return &QueryError{Path: "in", Err: errors.New("empty field")}
+	}
+	if len(c.values) == 0 {
+		return &QueryError{Path: "in", Err: fmt.Errorf("no values for %s", c.field)}
+	}
+	for i, v := range c.values {
+		if !scalar(v) {
+			return &QueryError{Path: fmt.Sprintf("in.values[%d]", i), Err: fmt.Errorf("must be a string, number or bool, got %T", v)}
+		}
+	}
+	return nil
+}
+
+type existsClause struct {
+	field string
+}
+
+// Exists matches the documents that have field.
+func Exists(field string) Clause {
+	return &existsClause{field: field}
+}
+
+func (c *existsClause) body() map[string]interface{} {
+	return map[string]interface{}{"exists": map[string]interface{}{"field": c.field}}
+}
+
+func (c *existsClause) validate() error {
+	if c.field == "" {
+		return &QueryError{Path: "exists", Err: errors.New("empty field")}
+	}
+	return nil
+}
+
+// RangeClause matches the documents whose field is within bounds.
+type RangeClause struct {
+	field  string
+	bounds map[string]interface{}
+}
+
+// Range starts a range clause on field, bounded with Gt, Gte, Lt and Lte.
+func Range(field string) *RangeClause {
+	return &RangeClause{field: field, bounds: make(map[string]interface{})}
+}
+
+// Gt bounds the range to values greater than v.
+func (c *RangeClause) Gt(v interface{}) *RangeClause { return c.bound("gt", v) }
+
+// Gte bounds the range to values greater than or equal to v.
+func (c *RangeClause) Gte(v interface{}) *RangeClause { return c.bound("gte", v) }
+
+// Lt bounds the range to values less than v.
+func (c *RangeClause) Lt(v interface{}) *RangeClause { return c.bound("lt", v) }
+
+// Lte bounds the range to values less than or equal to v.
+func (c *RangeClause) Lte(v interface{}) *RangeClause { return c.bound("lte", v) }
+
+func (c *RangeClause) bound(op string, v interface{}) *RangeClause {
+	c.bounds[op] = v
+	return c
+}
+
+func (c *RangeClause) body() map[string]interface{} {
+	return map[string]interface{}{"range": rangeBody(c.field, c.bounds)}
+}
+
+func (c *RangeClause) validate() error {
+	if err := validateBounds(c.field, c.bounds); err != nil {
+		return &QueryError{Path: "range", Err: err}
+	}
+	for op, v := range c.bounds {
+		if !scalar(v) || v == true || v == false {
+			return &QueryError{Path: "range." + op, Err: fmt.Errorf("must be a number or string, got %T", v)}
+		}
+	}
+	return nil
+}
+
+// DateRangeClause matches the documents whose date field is within bounds.
+type DateRangeClause struct {
+	field    string
+	bounds   map[string]interface{}
+	format   string
+	timeZone string
+}
+
+// DateRange starts a date_range clause on field, bounded with Gt, Gte, Lt
+// and Lte.
+func DateRange(field string) *DateRangeClause {
+	return &DateRangeClause{field: field, bounds: make(map[string]interface{})}
+}
+
+// Gt bounds the range to dates after date.
+func (c *DateRangeClause) Gt(date string) *DateRangeClause { return c.bound("gt", date) }
+
+// Gte bounds the range to dates from date on.
+func (c *DateRangeClause) Gte(date string) *DateRangeClause { return c.bound("gte", date) }
+
+// Lt bounds the range to dates before date.
+func (c *DateRangeClause) Lt(date string) *DateRangeClause { return c.bound("lt", date) }
+
+// Lte bounds the range to dates up to date.
+func (c *DateRangeClause) Lte(date string) *DateRangeClause { return c.bound("lte", date) }
+
+// Format is the format of the bounds, e.g. YYYY-MM-dd.
+func (c *DateRangeClause) Format(format string) *DateRangeClause {
+	c.format = format
+	return c
+}
+
+// TZ is the time zone of the bounds, an offset like -04:00 or a zone like
+// America/Argentina/Buenos_Aires.
+func (c *DateRangeClause) TZ(timeZone string) *DateRangeClause {
+	c.timeZone = timeZone
+	return c
+}
+
+func (c *DateRangeClause) bound(op string, date string) *DateRangeClause {
+	c.bounds[op] = date
+	return c
+}
+
+func (c *DateRangeClause) body() map[string]interface{} {
+	body := rangeBody(c.field, c.bounds)
+	if c.format != "" {
+		body["format"] = c.format
+	}
+	if c.timeZone != "" {
+		body["time_zone"] = c.timeZone
+	}
+	return map[string]interface{}{"date_range": body}
+}
+
+func (c *DateRangeClause) validate() error {
+	if err := validateBounds(c.field, c.bounds); err != nil {
+		return &QueryError{Path: "date_range", Err: err}
+	}
+	for op, v := range c.bounds {
+		if _, ok := number(v); ok {
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			return &QueryError{Path: "date_range." + op, Err: fmt.Errorf("must be a date or epoch milliseconds, got %T", v)}
+		}
+		if s == "" {
+			return &QueryError{Path: "date_range." + op, Err: errors.New("empty date")}
+		}
+	}
+	if c.timeZone != "" && !validTimeZone(c.timeZone) {
+		return &QueryError{Path: "date_range.time_zone", Err: fmt.Errorf("%q is not an offset like -04:00 or a zone like America/Argentina/Buenos_Aires", c.timeZone)}
+	}
+	return nil
+}
+
+var offsetRe = regexp.MustCompile(`^(Z|[+-]\d{2}:?\d{2})$`)
+
+// validTimeZone tells whether tz is an offset or the name of a zone of the
+// tz database.
+func validTimeZone(tz string) bool {
+	if offsetRe.MatchString(tz) {
+		return true
+	}
+	if tz == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(tz)
+	return err == nil
+}
+
+func rangeBody(field string, bounds map[string]interface{}) map[string]interface{} {
+	body := map[string]interface{}{"field": field}
+	for op, v := range bounds {
+		body[op] = v
+	}
+	return body
+}
+
+func validateBounds(field string, bounds map[string]interface{}) error {
+	if field == "" {
+		return errors.New("empty field")
+	}
+	if len(bounds) == 0 {
+		return fmt.Errorf("no bounds for %s, use gt, gte, lt or lte", field)
+	}
+	if bounds["gt"] != nil && bounds["gte"] != nil {
+		return errors.New("both gt and gte")
+	}
+	if bounds["lt"] != nil && bounds["lte"] != nil {
+		return errors.New("both lt and lte")
+	}
+	return nil
+}
+
+func scalar(v interface{}) bool {
+	switch v.(type) {
+	case string, bool:
+		return true
+	}
+	_, ok := number(v)
+	return ok
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// Query is a search body: a clause plus projections, sort and size.
+type Query struct {
+	clause      Clause
+	projections []string
+	sort        []interface{}
+	size        int
+	extra       map[string]interface{}
+}
+
+// NewQuery starts a search body matching clause.
+func NewQuery(clause Clause) *Query {
+	return &Query{clause: clause, extra: make(map[string]interface{})}
+}
+
+// Project sets the fields of the documents returned.
+func (q *Query) Project(fields ...string) *Query {
+	q.projections = append(q.projections, fields...)
+	return q
+}
+
+// SortAsc sorts by field, ascending. Later sorts break the ties of the
+// earlier ones.
+func (q *Query) SortAsc(field string) *Query {
+	q.sort = append(q.sort, map[string]interface{}{"field": field, "order": "asc"})
+	return q
+}
+
+// SortDesc sorts by field, descending.
+func (q *Query) SortDesc(field string) *Query {
+	q.sort = append(q.sort, map[string]interface{}{"field": field, "order": "desc"})
+	return q
+}
+
+// Size sets the page size.
+func (q *Query) Size(size int) *Query {
+	q.size = size
+	return q
+}
+
+// Set sets any other key of the body, e.g. secondary_search.
+func (q *Query) Set(key string, value interface{}) *Query {
+	q.extra[key] = value
+	return q
+}
+
+// Validate checks the shape of every clause, projection and sort.
+func (q *Query) Validate() error {
+	if q.clause == nil {
+		return &QueryError{Path: "query", Err: errors.New("no clause")}
+	}
+	if err := q.clause.validate(); err != nil {
+		return nest("query", err)
+	}
+	for i, p := range q.projections {
+		if p == "" {
+			return &QueryError{Path: fmt.Sprintf("projections[%d]", i), Err: errors.New("empty field")}
+		}
+	}
+	for i, s := range q.sort {
+		if s.(map[string]interface{})["field"] == "" {
+			return &QueryError{Path: fmt.Sprintf("sort[%d]", i), Err: errors.New("empty field")}
+		}
+	}
+	if q.size < 0 {
+		return &QueryError{Path: "size", Err: fmt.Errorf("negative size %d", q.size)}
+	}
+	for _, key := range []string{"query", "projections", "sort", "size"} {
+		if _, ok := q.extra[key]; ok {
+			return &QueryError{Path: key, Err: errors.New("cannot be Set, use NewQuery, Project, SortAsc, SortDesc or Size")}
+		}
+	}
+	return nil
+}
+
+// Build validates the query and returns the body to scroll, as
+// NewScroller and Search take it.
+func (q *Query) Build() (*gabs.Container, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	body := gabs.New()
+	body.Set(q.clause.body(), "query")
+	if len(q.projections) > 0 {
+		body.Set(q.projections, "projections")
+	}
+	switch len(q.sort) {
+	case 0:
+	case 1:
+		body.Set(q.sort[0], "sort")
+	default:
+		body.Set(q.sort, "sort")
+	}
+	if q.size > 0 {
+		body.Set(q.size, "size")
+	}
+
+	keys := make([]string, 0, len(q.extra))
+	for key := range q.extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		body.Set(q.extra[key], key)
+	}
+
+	// A round trip leaves the body as a parsed one would be, with numbers as
+	// json.Number, so that ids above 2^53 keep their digits.
+	return Config{UseNumber: true}.parse(body.Bytes())
+}
+
+// ValidateQuery checks the clauses of a search body the way Build does, for
+// bodies written by hand. Only and, or, not, eq, in, exists, range and
+// date_range are checked, other operators are left to DS.
+func ValidateQuery(body *gabs.Container) error {
+	return validateRaw("query", body.S("query"))
+}
+
+func validateRaw(path string, c *gabs.Container) error {
+	clauses, ok := c.Data().(map[string]interface{})
+	if !ok {
+		return &QueryError{Path: path, Err: fmt.Errorf("clause must be an object, got %s", c.String())}
+	}
+	ops := make([]string, 0, len(clauses))
+	for op := range clauses {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	for _, op := range ops {
+		v := c.S(op)
+		var clause Clause
+		switch op {
+		case "and", "or":
+			children, ok := v.Data().([]interface{})
+			if !ok {
+				return &QueryError{Path: path + "." + op, Err: errors.New("must be a list of clauses")}
+			}
+			if len(children) == 0 {
+				return &QueryError{Path: path + "." + op, Err: errors.New("needs at least one clause")}
+			}
+			for i := range children {
+				if err := validateRaw(fmt.Sprintf("%s.%s[%d]", path, op, i), v.Index(i)); err != nil {
+					return err
+				}
+			}
+			continue
+		case "not":
+			if err := validateRaw(path+".not", v); err != nil {
+				return err
+			}
+			continue
+		case "eq":
+			clause = Eq(rawField(v), v.Path("value").Data())
+		case "in":
+			values, ok := v.Path("values").Data().([]interface{})
+			if !ok {
+				return &QueryError{Path: path + ".in.values", Err: errors.New("must be a list")}
+			}
+			clause = In(rawField(v), values...)
+		case "exists":
+			clause = Exists(rawField(v))
+		case "range":
+			r := Range(rawField(v))
+			rawBounds(v, r.bounds)
+			clause = r
+		case "date_range":
+			r := DateRange(rawField(v))
+			rawBounds(v, r.bounds)
+			r.timeZone, _ = v.Path("time_zone").Data().(string)
+			clause = r
+		default:
+			continue
+		}
+		if err := clause.validate(); err != nil {
+			return nest(path, err)
+		}
+	}
+	return nil
+}
+
+func rawField(c *gabs.Container) string {
+	field, _ := c.Path("field").Data().(string)
+	return field
+}
+
+func rawBounds(c *gabs.Container, bounds map[string]interface{}) {
+	for _, op := range []string{"gt", "gte", "lt", "lte"} {
+		if v := c.Path(op).Data(); v != nil {
+			bounds[op] = v
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package ds_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Jeffail/gabs"
+	"github.com/aranajuanm/dsScroller/ds"
+)
+
+func TestValidateQuery(t *testing.T) {
+	for _, c := range []struct {
+		body string
+		// path is the Path of the *ds.QueryError, empty for a valid body.
+		path string
+	}{
+		{body: `{"query":{"eq":{"field":"status","value":"ok"}}}`},
+		{body: `{"query":{"and":[{"exists":{"field":"id"}},{"not":{"in":{"field":"status","values":["a","b"]}}}]}}`},
+		{body: `{"query":{"or":[{"range":{"field":"amount","gte":10,"lt":20}},{"eq":{"field":"paid","value":true}}]}}`},
+		{body: `{"query":{"date_range":{"field":"date_created","gte":"2019-01-01","lt":"2019-02-01","time_zone":"-04:00"}}}`},
+		{body: `{"query":{"date_range":{"field":"date_created","gte":"2019-01-01","time_zone":"America/Argentina/Buenos_Aires"}}}`},
+		{body: `{"query":{"date_range":{"field":"date_created","gte":1546300800000,"lt":1548979200000}}}`},
+		{body: `{"query":{"match_phrase":{"anything":"goes"}}}`},
+
+		{body: `{}`, path: "query"},
+		{body: `{"query":[]}`, path: "query"},
+		{body: `{"query":{"and":[]}}`, path: "query.and"},
+		{body: `{"query":{"or":{"eq":{"field":"a","value":1}}}}`, path: "query.or"},
+		{body: `{"query":{"and":[{"exists":{"field":"id"}},{"eq":{"field":"","value":1}}]}}`, path: "query.and[1].eq"},
+		{body: `{"query":{"eq":{"field":"status","value":["ok"]}}}`, path: "query.eq"},
+		{body: `{"query":{"not":{"exists":{}}}}`, path: "query.not.exists"},
+		{body: `{"query":{"in":{"field":"status","values":"ok"}}}`, path: "query.in.values"},
+		{body: `{"query":{"in":{"field":"status","values":[]}}}`, path: "query.in"},
+		{body: `{"query":{"range":{"field":"amount"}}}`, path: "query.range"},
+		{body: `{"query":{"range":{"field":"amount","gt":1,"gte":2}}}`, path: "query.range"},
+		{body: `{"query":{"date_range":{"field":"date_created","gte":""}}}`, path: "query.date_range.gte"},
+		{body: `{"query":{"date_range":{"field":"date_created","gte":true}}}`, path: "query.date_range.gte"},
+		{body: `{"query":{"date_range":{"field":"date_created","gte":"2019-01-01","time_zone":"Mars/Olympus_Mons"}}}`, path: "query.date_range.time_zone"},
+		{body: `{"query":{"date_range":{"field":"date_created","gte":"2019-01-01","time_zone":"Local"}}}`, path: "query.date_range.time_zone"},
+	} {
+		body, err := gabs.ParseJSON([]byte(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ds.ValidateQuery(body)
+		if c.path == "" {
+			if err != nil {
+				t.Errorf("ValidateQuery(%s): %v", c.body, err)
+			}
+			continue
+		}
+		var qe *ds.QueryError
+		if !errors.As(err, &qe) {
+			t.Errorf("ValidateQuery(%s) = %v, want a *ds.QueryError at %s", c.body, err, c.path)
+		} else if qe.Path != c.path {
+			t.Errorf("ValidateQuery(%s) fails at %s (%v), want %s", c.body, qe.Path, err, c.path)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	body, err := ds.NewQuery(ds.And(
+		ds.Eq("status", "ok"),
+		ds.Not(ds.In("site", "MLA", "MLB")),
+		ds.Range("amount").Gte(10).Lt(20),
+		ds.DateRange("date_created").Gte("2019-01-01").Lt("2019-02-01").Format("YYYY-MM-dd").TZ("-04:00"),
+	)).Project("id", "amount").SortAsc("id").Size(100).Set("secondary_search", true).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := gabs.ParseJSON([]byte(`{
+		"query": {"and": [
+			{"eq": {"field": "status", "value": "ok"}},
+			{"not": {"in": {"field": "site", "values": ["MLA", "MLB"]}}},
+			{"range": {"field": "amount", "gte": 10, "lt": 20}},
+			{"date_range": {"field": "date_created", "gte": "2019-01-01", "lt": "2019-02-01", "format": "YYYY-MM-dd", "time_zone": "-04:00"}}
+		]},
+		"projections": ["id", "amount"],
+		"sort": {"field": "id", "order": "asc"},
+		"size": 100,
+		"secondary_search": true
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body.String() != want.String() {
+		t.Errorf("Build() = %s, want %s", body, want)
+	}
+	if err := ds.ValidateQuery(body); err != nil {
+		t.Errorf("ValidateQuery(Build()): %v", err)
+	}
+
+	// Integers above 2^53 are sent with all their digits.
+	body, err = ds.NewQuery(ds.And(
+		ds.Eq("id", int64(9007199254740993)),
+		ds.Range("id").Gt(int64(12345678901234567)),
+	)).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"query":{"and":[{"eq":{"field":"id","value":9007199254740993}},{"range":{"field":"id","gt":12345678901234567}}]}}`; body.String() != want {
+		t.Errorf("Build() = %s, want %s", body, want)
+	}
+	if err := ds.ValidateQuery(body); err != nil {
+		t.Errorf("ValidateQuery(Build()): %v", err)
+	}
+
+	for _, c := range []struct {
+		query *ds.Query
+		path  string
+	}{
+		{ds.NewQuery(nil), "query"},
+		{ds.NewQuery(ds.Or()), "query.or"},
+		{ds.NewQuery(ds.And(ds.Exists("id"), nil)), "query.and[1]"},
+		{ds.NewQuery(ds.Not(ds.Eq("tags", []string{"a"}))), "query.not.eq"},
+		{ds.NewQuery(ds.DateRange("date_created").Lt("2019-01-01").TZ("-4")), "query.date_range.time_zone"},
+		{ds.NewQuery(ds.Exists("id")).Project(""), "projections[0]"},
+		{ds.NewQuery(ds.Exists("id")).SortDesc(""), "sort[0]"},
+		{ds.NewQuery(ds.Exists("id")).Size(-1), "size"},
+		{ds.NewQuery(ds.Exists("id")).Set("sort", "id"), "sort"},
+	} {
+		_, err := c.query.Build()
+		var qe *ds.QueryError
+		if !errors.As(err, &qe) || qe.Path != c.path {
+			t.Errorf("Build() = %v, want a *ds.QueryError at %s", err, c.path)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package ds
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Jeffail/gabs"
+	"github.com/mercadolibre/go-meli-toolkit/restful/rest"
+	"github.com/mercadolibre/go-meli-toolkit/restful/rest/retry"
+)
+
+// RetryPolicy decides which failed requests of a scroll are sent again and
+// how long to wait before. Only 5xx, 429 and timeouts are retried, with an
+// exponential backoff with jitter that a Retry-After header can lengthen.
+//
+// After a timeout or a 5xx the request may have been served, and the scroll
+// may have moved past the page asked for. The Scroller drops every page that
+// is the same as the one before it, so the page a retry returns again is
+// not returned twice.
+type RetryPolicy struct {
+	// MaxRetries is how many times a page is asked again before giving up.
+	MaxRetries int
+	// MinDelay is the delay of the first retry, doubled on every next one.
+	MinDelay time.Duration
+	// MaxDelay caps the backoff and any Retry-After.
+	MaxDelay time.Duration
+	// Budget is how many retries the whole scroll may do, none when 0.
+	Budget int
+}
+
+// DefaultRetryPolicy is used when Config.Retry is nil.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 8,
+	MinDelay:   500 * time.Millisecond,
+	MaxDelay:   time.Minute,
+}
+
+// backoff is the delay of the retry number retries, from 0, with the
+// vendored exponential backoff. It is asked as if the request had failed,
+// since its own rules (GET only, 5xx only) are not the ones of a scroll.
+func (p RetryPolicy) backoff(retries int) time.Duration {
+	strategy := retry.NewExponentialBackoffRetryStrategy(p.MinDelay, p.MaxDelay+1, http.MethodPost)
+	if strategy == nil {
+		return p.MinDelay
+	}
+	response := strategy.ShouldRetry(&http.Request{Method: http.MethodPost}, nil, errRetry, retries)
+	if !response.Retry() {
+		return p.MaxDelay
+	}
+	return response.Delay()
+}
+
+var errRetry = errors.New("retry")
+
+// delay is how long to wait before retry number retries of a response.
+func (p RetryPolicy) delay(retries int, response *rest.Response) time.Duration {
+	d := p.backoff(retries)
+	if after := retryAfter(response); after > d {
+		d = after
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// retryAfter reads the Retry-After header, in seconds or as a date.
+func retryAfter(response *rest.Response) time.Duration {
+	if response == nil || response.Response == nil {
+		return 0
+	}
+	value := response.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
+
+// retryReason tells whether a request should be retried and why: the
+// status, or "timeout".
+func retryReason(response *rest.Response, err error) (reason string, ok bool) {
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return "timeout", true
+		}
+		return "", false
+	}
+	if response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500 {
+		return strconv.Itoa(response.StatusCode), true
+	}
+	return "", false
+}
+
+// RetryBudgetError is returned when a page failed more times than the retry
+// policy allows. Err is the last failure.
+type RetryBudgetError struct {
+	ScrollID string
+	Retries  int
+	Err      error
+}
+
+func (e *RetryBudgetError) Error() string {
+	return fmt.Sprintf("gave up after %d retries: %v", e.Retries, e.Err)
+}
+
+func (e *RetryBudgetError) Unwrap() error {
+	return e.Err
+}
+
+// fingerprint identifies the documents of a page.
+func fingerprint(docs []*gabs.Container) [sha256.Size]byte {
+	var b bytes.Buffer
+	for _, doc := range docs {
+		b.Write(doc.Bytes())
+		b.WriteByte('\n')
+	}
+	return sha256.Sum256(b.Bytes())
+}
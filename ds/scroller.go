@@ -0,0 +1,452 @@
+// Package ds scrolls the search API of DS services through their read
+// proxy, page by page.
+//
+//	s := ds.NewScroller(ds.Config{URL: url, Token: token}, request)
+//	err := s.ForEach(ctx, func(docs []*gabs.Container) error {
+//		...
+//	})
+package ds
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Jeffail/gabs"
+	"github.com/mercadolibre/go-meli-toolkit/restful/rest"
+)
+
+// Config configures a Scroller.
+type Config struct {
+	// URL is the search URL of the service on the read proxy.
+	URL string
+	// Token is the fury token sent as x-auth-token.
+	Token string
+	// PageSize, when positive, is the size of the first request. Otherwise
+	// the size of the request is used as is.
+	PageSize int
+	// Sleep is the time waited before asking for every page after the first.
+	Sleep time.Duration
+	// Pacer, when set, paces the requests, see NewPacer.
+	Pacer *Pacer
+	// Retry is the retry policy, DefaultRetryPolicy when nil.
+	Retry *RetryPolicy
+	// Timeout of every request, none when 0.
+	Timeout time.Duration
+	// MetricsTarget tags the API call metrics the rest client records.
+	MetricsTarget string
+	// UseNumber parses the numbers of the responses as json.Number instead
+	// of float64, so that ids and amounts keep all their digits, see Decode.
+	UseNumber bool
+
+	// OnResponse, when set, is called after every request with the response,
+	// whose Err is set when there was none, and how long it took.
+	OnResponse func(response *rest.Response, latency time.Duration)
+	// OnRetry, when set, is called before every retry with its reason, a
+	// status or "timeout", and the delay before it.
+	OnRetry func(reason string, delay time.Duration)
+	// Seen, when set, drops the documents whose id was added to it already,
+	// by this or any other Scroller sharing it.
+	Seen IDSet
+	// IDField is the field holding the id of the documents, "id" when empty.
+	// Documents without it are never dropped.
+	IDField string
+
+	// OnDuplicate, when set, is called with every page dropped for being the
+	// same as the one before, as a retry or DS can answer, or for holding
+	// only documents returned before.
+	OnDuplicate func(docs []*gabs.Container)
+	// OnDropped, when set, is called with every document dropped because its
+	// id was seen before.
+	OnDropped func(doc *gabs.Container)
+}
+
+func (c Config) retryPolicy() RetryPolicy {
+	if c.Retry == nil {
+		return DefaultRetryPolicy
+	}
+	return *c.Retry
+}
+
+// parse parses a response body, with json.Number numbers when c says so.
+func (c Config) parse(body []byte) (*gabs.Container, error) {
+	if !c.UseNumber {
+		return gabs.ParseJSON(body)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	return gabs.ParseJSONDecoder(decoder)
+}
+
+// client builds the rest client of c. Every Scroller has its own, so that
+// concurrent scrollers never share headers. It never retries, the Scroller
+// does, knowing which requests are safe to send again.
+func (c Config) client() *rest.RequestBuilder {
+	headers := make(http.Header)
+	headers.Add("x-auth-token", c.Token)
+	headers.Add("Content-Type", "application/json")
+	return &rest.RequestBuilder{
+		Headers:        headers,
+		Timeout:        c.Timeout,
+		ContentType:    rest.BYTES,
+		DisableTimeout: c.Timeout == 0,
+		EnableCache:    false,
+		CustomPool:     &rest.CustomPool{MaxIdleConnsPerHost: 4},
+		MetricsConfig:  rest.MetricsReportConfig{TargetId: c.MetricsTarget},
+	}
+}
+
+// Scroller iterates over the pages of a scroll. It is not safe for
+// concurrent use, run one Scroller per scroll.
+type Scroller struct {
+	config  Config
+	client  *rest.RequestBuilder
+	request *gabs.Container
+
+	page       int
+	total      int64
+	hasTotal   bool
+	retries    int
+	duplicates int
+	dropped    int
+	documents  int64
+	ended      bool
+	last       [sha256.Size]byte
+	err        error
+}
+
+// NewScroller returns a Scroller for request, a search body with a
+// "query". request is updated in place with the scroll_id of every page, so
+// it can be saved and scrolled again later from where it was left.
+func NewScroller(config Config, request *gabs.Container) *Scroller {
+	request.Set("scroll", "type")
+	if config.PageSize > 0 && !request.Exists("scroll_id") {
+		request.Set(config.PageSize, "size")
+	}
+	return &Scroller{config: config, client: config.client(), request: request}
+}
+
+// Next returns the documents of the next page. It returns io.EOF once DS
+// answers with an empty page and, after any error, keeps returning it. The
+// other errors are the *Error types of this package, or ctx.Err().
+func (s *Scroller) Next(ctx context.Context) ([]*gabs.Container, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	docs, err := s.next(ctx)
+	if err != nil {
+		s.err = err
+		return nil, err
+	}
+	s.page++
+	return docs, nil
+}
+
+// maxRepeatedPages is how many times in a row DS may answer the page before
+// again before the scroll gives up with a *RepeatedPageError.
+const maxRepeatedPages = 5
+
+func (s *Scroller) next(ctx context.Context) ([]*gabs.Container, error) {
+	if s.page > 0 && s.config.Sleep > 0 {
+		if err := sleep(ctx, s.config.Sleep); err != nil {
+			return nil, err
+		}
+	}
+
+	scrollID := s.ScrollID()
+	last, hasLast := s.last, s.page > 0
+	repeats := 0
+	for {
+		response, err := s.send(ctx, scrollID)
+		if err != nil {
+			return nil, err
+		}
+
+		if response.StatusCode != http.StatusOK {
+			if isAuthFailure(response.StatusCode) {
+				return nil, &AuthError{StatusCode: response.StatusCode, Body: response.String()}
+			}
+			if isScrollExpired(s.request, response) {
+				return nil, &ScrollExpiredError{ScrollID: scrollID}
+			}
+			return nil, &StatusError{StatusCode: response.StatusCode, Body: response.String(), ScrollID: scrollID}
+		}
+
+		parsed, err := s.config.parse(response.Bytes())
+		if err != nil {
+			return nil, &MalformedResponseError{Body: response.String(), Err: err}
+		}
+		if s.page == 0 && scrollID == "" {
+			s.total, s.hasTotal = Total(parsed)
+		}
+
+		docs, _ := parsed.S("documents").Children()
+		if len(docs) == 0 {
+			s.ended = true
+			return nil, io.EOF
+		}
+
+		nextScrollID, ok := parsed.Path("scroll_id").Data().(string)
+		if !ok || nextScrollID == "" {
+			return nil, &MalformedResponseError{Body: response.String(), Err: errors.New("page has no scroll_id")}
+		}
+		s.request.Set(nextScrollID, "scroll_id")
+		s.request.Delete("size")
+
+		// A retried request that had been served already, or a scroll asked
+		// twice, gets the page before again, which was returned already.
+		sum := fingerprint(docs)
+		repeated := hasLast && sum == last
+		duplicate := repeated
+		if !duplicate && s.config.Seen != nil {
+			kept, dropped := s.unseen(docs)
+			duplicate = len(kept) == 0
+			if !duplicate {
+				docs = kept
+				s.dropped += len(dropped)
+				for _, doc := range dropped {
+					if s.config.OnDropped != nil {
+						s.config.OnDropped(doc)
+					}
+				}
+			}
+		}
+		if duplicate {
+			s.duplicates++
+			if s.config.OnDuplicate != nil {
+				s.config.OnDuplicate(docs)
+			}
+			// A scroll stuck on a page would be asked for it forever.
+			if repeated {
+				repeats++
+			} else {
+				repeats = 0
+			}
+			if repeats >= maxRepeatedPages {
+				return nil, &RepeatedPageError{ScrollID: scrollID, Pages: repeats}
+			}
+			last, hasLast = sum, true
+			scrollID = nextScrollID
+			// The page after is asked for as any other: after Sleep, and
+			// when the Pacer allows it.
+			if s.config.Sleep > 0 {
+				if err := sleep(ctx, s.config.Sleep); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		s.last = sum
+		s.documents += int64(len(docs))
+		return docs, nil
+	}
+}
+
+// unseen splits the documents of a page into those not seen before and
+// those whose id was.
+func (s *Scroller) unseen(docs []*gabs.Container) (kept []*gabs.Container, dropped []*gabs.Container) {
+	field := s.config.IDField
+	if field == "" {
+		field = "id"
+	}
+	for _, doc := range docs {
+		if id, ok := documentID(doc, field); ok && !s.config.Seen.Add(id) {
+			dropped = append(dropped, doc)
+			continue
+		}
+		kept = append(kept, doc)
+	}
+	return kept, dropped
+}
+
+// send posts the current request, retrying it as the retry policy says.
+// The response returned is a success or a failure not to retry.
+func (s *Scroller) send(ctx context.Context, scrollID string) (*rest.Response, error) {
+	policy := s.config.retryPolicy()
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		response, err := s.post(s.request)
+		reason, retry := retryReason(response, err)
+		if !retry {
+			if err != nil {
+				return nil, &TransportError{ScrollID: scrollID, Err: err}
+			}
+			return response, nil
+		}
+
+		if attempt >= policy.MaxRetries || (policy.Budget > 0 && s.retries >= policy.Budget) {
+			failure := error(&TransportError{ScrollID: scrollID, Err: err})
+			if err == nil {
+				failure = &StatusError{StatusCode: response.StatusCode, Body: response.String(), ScrollID: scrollID}
+			}
+			return nil, &RetryBudgetError{ScrollID: scrollID, Retries: attempt, Err: failure}
+		}
+
+		delay := policy.delay(attempt, response)
+		if s.config.OnRetry != nil {
+			s.config.OnRetry(reason, delay)
+		}
+		s.retries++
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// post sends a request through the pacer, returning the response or why
+// there was none.
+func (s *Scroller) post(request *gabs.Container) (*rest.Response, error) {
+	s.config.Pacer.Wait()
+	start := time.Now()
+	response := s.client.Post(s.config.URL, request.Bytes())
+	latency := time.Since(start)
+	if s.config.OnResponse != nil {
+		s.config.OnResponse(response, latency)
+	}
+	if response.Err != nil {
+		s.config.Pacer.Observe(0, latency)
+		return nil, response.Err
+	}
+	s.config.Pacer.Observe(response.StatusCode, latency)
+	return response, nil
+}
+
+// ForEach calls fn with the documents of every page until the scroll ends,
+// which is not an error. It stops at the first error, a *CallbackError when
+// fn failed.
+func (s *Scroller) ForEach(ctx context.Context, fn func(docs []*gabs.Container) error) error {
+	for {
+		docs, err := s.Next(ctx)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(docs); err != nil {
+			return &CallbackError{Page: s.page, Err: err}
+		}
+	}
+}
+
+// Retries is the number of requests retried so far.
+func (s *Scroller) Retries() int {
+	return s.retries
+}
+
+// Duplicates is the number of pages dropped, see Config.OnDuplicate.
+func (s *Scroller) Duplicates() int {
+	return s.duplicates
+}
+
+// Dropped is the number of documents dropped because their id was seen
+// before, in pages that were not dropped whole.
+func (s *Scroller) Dropped() int {
+	return s.dropped
+}
+
+// Missing is how many documents the scroll returned fewer than the total
+// DS announced, once it has ended. It is 0 while the scroll goes on and
+// when the total is unknown.
+func (s *Scroller) Missing() int64 {
+	if !s.ended || !s.hasTotal || s.documents >= s.total {
+		return 0
+	}
+	return s.total - s.documents
+}
+
+// Page is the number of pages returned so far.
+func (s *Scroller) Page() int {
+	return s.page
+}
+
+// ScrollID is the scroll_id the next page will be asked with, empty before
+// the first one.
+func (s *Scroller) ScrollID() string {
+	id, _ := s.request.Path("scroll_id").Data().(string)
+	return id
+}
+
+// Total is how many documents the query matches, as DS answered to the
+// first request of a scroll. It is unknown until then, and when the
+// Scroller continues a scroll started elsewhere.
+func (s *Scroller) Total() (int64, bool) {
+	return s.total, s.hasTotal
+}
+
+// Search sends request as a single, non scroll, request and returns the
+// parsed response. Errors are the same as those of Scroller.Next.
+func Search(ctx context.Context, config Config, request *gabs.Container) (*gabs.Container, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s := &Scroller{config: config, client: config.client(), request: request}
+	response, err := s.send(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	if isAuthFailure(response.StatusCode) {
+		return nil, &AuthError{StatusCode: response.StatusCode, Body: response.String()}
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: response.StatusCode, Body: response.String()}
+	}
+
+	parsed, err := config.parse(response.Bytes())
+	if err != nil {
+		return nil, &MalformedResponseError{Body: response.String(), Err: err}
+	}
+	return parsed, nil
+}
+
+// Total is the number of documents matching the query of a DS response,
+// when it says so.
+func Total(response *gabs.Container) (int64, bool) {
+	for _, path := range []string{"paging.total", "total", "hits.total"} {
+		switch total := response.Path(path).Data().(type) {
+		case float64:
+			return int64(total), true
+		case json.Number:
+			if n, err := total.Int64(); err == nil {
+				return n, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func isAuthFailure(status int) bool {
+	return status == http.StatusUnauthorized || status == http.StatusForbidden
+}
+
+// isScrollExpired tells whether a failed scroll continuation was rejected
+// because its scroll context is gone rather than for any other reason.
+func isScrollExpired(request *gabs.Container, response *rest.Response) bool {
+	if !request.Exists("scroll_id") {
+		return false
+	}
+	if response.StatusCode == http.StatusNotFound {
+		return true
+	}
+	body := strings.ToLower(response.String())
+	return strings.Contains(body, "scroll") && (strings.Contains(body, "expired") || strings.Contains(body, "not found"))
+}
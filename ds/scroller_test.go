@@ -0,0 +1,220 @@
+package ds_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/gabs"
+	"github.com/aranajuanm/dsScroller/ds"
+	"github.com/aranajuanm/dsScroller/ds/dstest"
+	"github.com/aranajuanm/dsScroller/internal/testflags"
+)
+
+func TestMain(m *testing.M) {
+	testflags.Parse()
+	os.Exit(m.Run())
+}
+
+var testRetry = ds.RetryPolicy{MaxRetries: 2, MinDelay: time.Millisecond, MaxDelay: 2 * time.Second}
+
+// newScroller scrolls the documents of srv sorted by id, size at a time.
+func newScroller(t *testing.T, srv *dstest.Server, size int, config ds.Config) *ds.Scroller {
+	t.Helper()
+	request, err := gabs.ParseJSON([]byte(`{"query":{"and":[{"exists":{"field":"id"}}]},"sort":{"field":"id","order":"asc"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Set(size, "size")
+	config.URL = srv.URL
+	config.Token = "xyzzy"
+	if config.Retry == nil {
+		config.Retry = &testRetry
+	}
+	return ds.NewScroller(config, request)
+}
+
+// scrollAll returns the ids of the documents of every page of s, and the
+// error the scroll stopped with, nil at its end.
+func scrollAll(s *ds.Scroller) ([]float64, error) {
+	var ids []float64
+	for {
+		docs, err := s.Next(context.Background())
+		if err == io.EOF {
+			return ids, nil
+		}
+		if err != nil {
+			return ids, err
+		}
+		for _, doc := range docs {
+			id, _ := doc.Path("id").Data().(float64)
+			ids = append(ids, id)
+		}
+	}
+}
+
+func checkIDs(t *testing.T, ids []float64, n int) {
+	t.Helper()
+	if len(ids) != n {
+		t.Fatalf("got %d documents, want %d", len(ids), n)
+	}
+	for i, id := range ids {
+		if id != float64(i+1) {
+			t.Fatalf("document %d has id %v, want %d", i, id, i+1)
+		}
+	}
+}
+
+func TestScroll(t *testing.T) {
+	srv := dstest.NewServer(dstest.Documents(25))
+	defer srv.Close()
+
+	s := newScroller(t, srv, 10, ds.Config{})
+	ids, err := scrollAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIDs(t, ids, 25)
+	if total, ok := s.Total(); !ok || total != 25 {
+		t.Errorf("Total() = %d, %v, want 25, true", total, ok)
+	}
+	if s.Page() != 3 {
+		t.Errorf("Page() = %d, want 3", s.Page())
+	}
+	if s.Retries() != 0 || s.Duplicates() != 0 || s.Missing() != 0 {
+		t.Errorf("Retries, Duplicates, Missing = %d, %d, %d, want 0", s.Retries(), s.Duplicates(), s.Missing())
+	}
+}
+
+func TestScrollRetry(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		fault    func(srv *dstest.Server)
+		reason   string
+		minDelay time.Duration
+	}{
+		{"5xx", func(srv *dstest.Server) { srv.Fail(2, 503, 2) }, "503", 0},
+		{"429 with Retry-After", func(srv *dstest.Server) { srv.Throttle(3, 1) }, "429", time.Second},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			srv := dstest.NewServer(dstest.Documents(25))
+			defer srv.Close()
+			c.fault(srv)
+
+			var reasons []string
+			var delays []time.Duration
+			s := newScroller(t, srv, 10, ds.Config{
+				OnRetry: func(reason string, delay time.Duration) {
+					reasons = append(reasons, reason)
+					delays = append(delays, delay)
+				},
+			})
+			ids, err := scrollAll(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkIDs(t, ids, 25)
+			if s.Retries() != len(reasons) || len(reasons) == 0 {
+				t.Fatalf("Retries() = %d with %d OnRetry calls", s.Retries(), len(reasons))
+			}
+			for i, reason := range reasons {
+				if reason != c.reason {
+					t.Errorf("retry %d for %q, want %q", i, reason, c.reason)
+				}
+				if delays[i] < c.minDelay {
+					t.Errorf("retry %d after %v, want at least %v", i, delays[i], c.minDelay)
+				}
+			}
+		})
+	}
+}
+
+func TestScrollRetryBudget(t *testing.T) {
+	srv := dstest.NewServer(dstest.Documents(25))
+	defer srv.Close()
+	srv.Fail(2, 500, 3)
+
+	_, err := scrollAll(newScroller(t, srv, 10, ds.Config{}))
+	var budget *ds.RetryBudgetError
+	if !errors.As(err, &budget) {
+		t.Fatalf("got %v, want a *ds.RetryBudgetError", err)
+	}
+	var status *ds.StatusError
+	if !errors.As(err, &status) || status.StatusCode != 500 {
+		t.Errorf("got %v, want it to wrap a 500 *ds.StatusError", err)
+	}
+}
+
+func TestScrollExpired(t *testing.T) {
+	srv := dstest.NewServer(dstest.Documents(25))
+	defer srv.Close()
+	srv.Expire(2)
+
+	ids, err := scrollAll(newScroller(t, srv, 10, ds.Config{}))
+	var expired *ds.ScrollExpiredError
+	if !errors.As(err, &expired) {
+		t.Fatalf("got %v, want a *ds.ScrollExpiredError", err)
+	}
+	if len(ids) != 10 {
+		t.Errorf("got %d documents before the scroll expired, want 10", len(ids))
+	}
+}
+
+func TestScrollRepeat(t *testing.T) {
+	for _, times := range []int{1, 4, 5} {
+		srv := dstest.NewServer(dstest.Documents(25))
+		defer srv.Close()
+		srv.Repeat(2, times)
+
+		duplicates := 0
+		s := newScroller(t, srv, 10, ds.Config{
+			Sleep:       10 * time.Millisecond,
+			OnDuplicate: func(docs []*gabs.Container) { duplicates++ },
+		})
+		start := time.Now()
+		ids, err := scrollAll(s)
+		elapsed := time.Since(start)
+		if duplicates != times || s.Duplicates() != times {
+			t.Errorf("repeated %d times: %d OnDuplicate calls and Duplicates() = %d, want %d", times, duplicates, s.Duplicates(), times)
+		}
+		// Every page after the first is asked for after Sleep, the pages
+		// after those repeated too.
+		if requests := len(srv.Requests()); elapsed < time.Duration(requests-1)*10*time.Millisecond {
+			t.Errorf("repeated %d times: %d requests in %v, want Sleep between them", times, requests, elapsed)
+		}
+
+		if times < 5 {
+			if err != nil {
+				t.Fatalf("repeated %d times: %v", times, err)
+			}
+			checkIDs(t, ids, 25)
+			continue
+		}
+		// The scroll gives up on a page repeated 5 times in a row.
+		var repeated *ds.RepeatedPageError
+		if !errors.As(err, &repeated) || repeated.Pages != 5 {
+			t.Errorf("repeated %d times: got %v, want a *ds.RepeatedPageError after 5 pages", times, err)
+		}
+		if len(ids) != 10 {
+			t.Errorf("repeated %d times: got %d documents before the error, want 10", times, len(ids))
+		}
+	}
+}
+
+func TestScrollAuth(t *testing.T) {
+	srv := dstest.NewServer(dstest.Documents(25))
+	defer srv.Close()
+	srv.Token = "plugh"
+
+	_, err := scrollAll(newScroller(t, srv, 10, ds.Config{}))
+	var auth *ds.AuthError
+	if !errors.As(err, &auth) || auth.StatusCode != 401 {
+		t.Fatalf("got %v, want a 401 *ds.AuthError", err)
+	}
+	if requests := len(srv.Requests()); requests != 0 {
+		t.Errorf("%d requests reached the search, want none", requests)
+	}
+}
@@ -0,0 +1,169 @@
+package ds
+
+import (
+	"context"
+	"io"
+
+	"github.com/Jeffail/gabs"
+)
+
+// Page is a page of documents of a scroll, as a Stream hands it.
+type Page struct {
+	Documents []*gabs.Container
+	// Number is the 1-based number of the page in the scroll.
+	Number int
+	// ScrollID is the scroll_id the page after this one is asked with. A
+	// Stream has asked with it already when it prefetched that page, and
+	// DS answers a scroll_id once, so it only continues the scroll when
+	// nothing was fetched after this page.
+	ScrollID string
+}
+
+// Stream scrolls in the background, fetching the next pages while the
+// consumer handles the one it has. Pages come in order from C, which is
+// closed once the scroll ends or fails, and Err tells which.
+//
+//	st := s.Stream(ctx, 2)
+//	defer st.Close()
+//	for page := range st.C {
+//		...
+//	}
+//	if err := st.Err(); err != nil {
+//		...
+//	}
+//
+// The Scroller belongs to the Stream until C is closed. Only Total can be
+// called meanwhile, once the first page has been received.
+//
+// Pages are fetched before they are handled, so a scroll streamed can't
+// be checkpointed after every page and continued from there: the scroll_id
+// saved would have been used already by the pages prefetched. Use Next to
+// checkpoint.
+type Stream struct {
+	C <-chan *Page
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// Stream starts scrolling s in the background, with at most prefetch pages
+// (at least 1) fetched ahead of the one being handled. Once they are, it
+// waits for the consumer.
+func (s *Scroller) Stream(ctx context.Context, prefetch int) *Stream {
+	if prefetch < 1 {
+		prefetch = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	// The page waiting to be sent is one of the prefetched.
+	c := make(chan *Page, prefetch-1)
+	st := &Stream{C: c, cancel: cancel, done: make(chan struct{})}
+	go st.run(ctx, s, c)
+	return st
+}
+
+func (st *Stream) run(ctx context.Context, s *Scroller, c chan<- *Page) {
+	defer close(st.done)
+	defer close(c)
+	for {
+		docs, err := s.Next(ctx)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			st.err = err
+			return
+		}
+		select {
+		case c <- &Page{Documents: docs, Number: s.Page(), ScrollID: s.ScrollID()}:
+		case <-ctx.Done():
+			st.err = ctx.Err()
+			return
+		}
+	}
+}
+
+// Next returns the next page, like Scroller.Next: io.EOF once the scroll
+// has ended, and its error once it has failed.
+func (st *Stream) Next() (*Page, error) {
+	if page, ok := <-st.C; ok {
+		return page, nil
+	}
+	if err := st.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// Err waits for the scroll to stop and returns why: nil when it ended,
+// the error of Scroller.Next otherwise, or ctx.Err() when it was canceled
+// or closed. Pages fetched before an error are still sent to C.
+func (st *Stream) Err() error {
+	<-st.done
+	return st.err
+}
+
+// Close stops the scroll, dropping the pages prefetched, and waits for it.
+// It returns Err, and can be called more than once.
+func (st *Stream) Close() error {
+	st.cancel()
+	for range st.C {
+	}
+	return st.Err()
+}
+
+// DocumentStream is a Stream of documents rather than pages.
+type DocumentStream struct {
+	// C receives the documents in order, and is closed once the scroll
+	// ends or fails.
+	C <-chan *gabs.Container
+
+	pages *Stream
+	done  chan struct{}
+	err   error
+}
+
+// StreamDocuments starts scrolling s in the background, sending its
+// documents to a channel of buffer documents. The next page is fetched
+// while those of the current one are handled, and no further once the
+// channel is full.
+func (s *Scroller) StreamDocuments(ctx context.Context, buffer int) *DocumentStream {
+	if buffer < 0 {
+		buffer = 0
+	}
+	c := make(chan *gabs.Container, buffer)
+	d := &DocumentStream{C: c, pages: s.Stream(ctx, 1), done: make(chan struct{})}
+	go d.run(ctx, c)
+	return d
+}
+
+func (d *DocumentStream) run(ctx context.Context, c chan<- *gabs.Container) {
+	defer close(d.done)
+	defer close(c)
+	for page := range d.pages.C {
+		for _, doc := range page.Documents {
+			select {
+			case c <- doc:
+			case <-ctx.Done():
+				d.err = d.pages.Close()
+				return
+			}
+		}
+	}
+	d.err = d.pages.Err()
+}
+
+// Err waits for the scroll to stop and returns why, as Stream.Err.
+func (d *DocumentStream) Err() error {
+	<-d.done
+	return d.err
+}
+
+// Close stops the scroll, dropping the documents fetched, and waits for
+// it. It returns Err.
+func (d *DocumentStream) Close() error {
+	d.pages.cancel()
+	for range d.C {
+	}
+	return d.Err()
+}
@@ -0,0 +1,206 @@
+package ds_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aranajuanm/dsScroller/ds"
+	"github.com/aranajuanm/dsScroller/ds/dstest"
+)
+
+// settle waits for srv to get no new request for a while, and returns how
+// many it got.
+func settle(srv *dstest.Server) int {
+	n := len(srv.Requests())
+	for {
+		time.Sleep(50 * time.Millisecond)
+		m := len(srv.Requests())
+		if m == n {
+			return n
+		}
+		n = m
+	}
+}
+
+// checkStopped fails t if a goroutine of a stream is still running.
+func checkStopped(t *testing.T) {
+	t.Helper()
+	var stack string
+	for i := 0; i < 100; i++ {
+		b := make([]byte, 1<<20)
+		stack = string(b[:runtime.Stack(b, true)])
+		if !strings.Contains(stack, "ds.(*Stream).run") && !strings.Contains(stack, "ds.(*DocumentStream).run") {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("a stream is still running:\n%s", stack)
+}
+
+func TestStream(t *testing.T) {
+	srv := dstest.NewServer(dstest.Documents(95))
+	defer srv.Close()
+
+	st := newScroller(t, srv, 10, ds.Config{}).Stream(context.Background(), 3)
+	var ids []float64
+	for i := 1; ; i++ {
+		page, err := st.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Number != i {
+			t.Errorf("page %d is numbered %d", i, page.Number)
+		}
+		for _, doc := range page.Documents {
+			id, _ := doc.Path("id").Data().(float64)
+			ids = append(ids, id)
+		}
+	}
+	checkIDs(t, ids, 95)
+	if err := st.Close(); err != nil {
+		t.Errorf("Close after the end = %v, want nil", err)
+	}
+	checkStopped(t)
+}
+
+func TestStreamPrefetch(t *testing.T) {
+	srv := dstest.NewServer(dstest.Documents(100))
+	defer srv.Close()
+
+	// Nothing is received, so the stream stops after the 3 pages it may
+	// fetch ahead.
+	st := newScroller(t, srv, 10, ds.Config{}).Stream(context.Background(), 3)
+	if n := settle(srv); n != 3 {
+		t.Errorf("prefetched %d pages, want 3", n)
+	}
+	<-st.C
+	if n := settle(srv); n != 4 {
+		t.Errorf("fetched %d pages once one was received, want 4", n)
+	}
+
+	// Close returns while the stream is blocked on the full channel.
+	done := make(chan error)
+	go func() { done <- st.Close() }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Close = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close is blocked")
+	}
+	if _, ok := <-st.C; ok {
+		t.Error("got a page after Close")
+	}
+	checkStopped(t)
+}
+
+func TestStreamError(t *testing.T) {
+	srv := dstest.NewServer(dstest.Documents(50))
+	defer srv.Close()
+	srv.Fail(3, 500, 10)
+
+	st := newScroller(t, srv, 10, ds.Config{}).Stream(context.Background(), 4)
+	// The pages before the error are received first.
+	settle(srv)
+	var pages []int
+	for page := range st.C {
+		pages = append(pages, page.Number)
+	}
+	if len(pages) != 2 || pages[0] != 1 || pages[1] != 2 {
+		t.Errorf("got pages %v, want 1 and 2", pages)
+	}
+	var se *ds.StatusError
+	if err := st.Err(); !errors.As(err, &se) {
+		t.Errorf("Err = %v, want a *ds.StatusError", err)
+	}
+	if _, err := st.Next(); !errors.As(err, &se) {
+		t.Errorf("Next after the error = %v, want it again", err)
+	}
+	checkStopped(t)
+}
+
+func TestStreamCancel(t *testing.T) {
+	srv := dstest.NewServer(dstest.Documents(100))
+	defer srv.Close()
+	srv.Latency = 20 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	st := newScroller(t, srv, 10, ds.Config{}).Stream(ctx, 1)
+	if page := <-st.C; page == nil || page.Number != 1 {
+		t.Fatalf("got page %v, want page 1", page)
+	}
+	cancel()
+	for range st.C {
+	}
+	if err := st.Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Err = %v, want context.Canceled", err)
+	}
+	if n := len(srv.Requests()); n >= 10 {
+		t.Errorf("the canceled stream went on to make %d requests", n)
+	}
+	checkStopped(t)
+}
+
+func TestStreamDocuments(t *testing.T) {
+	srv := dstest.NewServer(dstest.Documents(45))
+	defer srv.Close()
+
+	d := newScroller(t, srv, 10, ds.Config{}).StreamDocuments(context.Background(), 5)
+	var ids []float64
+	for doc := range d.C {
+		id, _ := doc.Path("id").Data().(float64)
+		ids = append(ids, id)
+	}
+	if err := d.Err(); err != nil {
+		t.Fatal(err)
+	}
+	checkIDs(t, ids, 45)
+
+	// Unread, the documents fill the channel and the page being sent,
+	// while the page after is prefetched.
+	srv = dstest.NewServer(dstest.Documents(100))
+	defer srv.Close()
+	d = newScroller(t, srv, 10, ds.Config{}).StreamDocuments(context.Background(), 5)
+	if n := settle(srv); n != 2 {
+		t.Errorf("fetched %d pages for a channel of 5 documents, want 2", n)
+	}
+	if err := d.Close(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Close = %v, want context.Canceled", err)
+	}
+	checkStopped(t)
+
+	srv = dstest.NewServer(dstest.Documents(50))
+	defer srv.Close()
+	srv.Fail(2, 500, 10)
+	d = newScroller(t, srv, 10, ds.Config{}).StreamDocuments(context.Background(), 0)
+	n := 0
+	for range d.C {
+		n++
+	}
+	var se *ds.StatusError
+	if err := d.Err(); n != 10 || !errors.As(err, &se) {
+		t.Errorf("got %d documents and %v, want 10 and a *ds.StatusError", n, err)
+	}
+
+	srv = dstest.NewServer(dstest.Documents(100))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	d = newScroller(t, srv, 10, ds.Config{}).StreamDocuments(ctx, 0)
+	<-d.C
+	cancel()
+	for range d.C {
+	}
+	if err := d.Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Err after cancel = %v, want context.Canceled", err)
+	}
+	checkStopped(t)
+}
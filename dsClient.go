@@ -1,66 +1,141 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
 	"github.com/Jeffail/gabs"
-	"net/http"
+	"github.com/aranajuanm/dsScroller/ds"
 	"github.com/mercadolibre/go-meli-toolkit/restful/rest"
-	"time"
-	"github.com/mercadolibre/go-meli-toolkit/restful/rest/retry"
-	"fmt"
 )
 
-var restDsClient *rest.RequestBuilder
-
-func process(url string, token string,request *gabs.Container, sleep int, callback func(response []*gabs.Container) error) {
-	restDsClient.Headers = make(http.Header)
-	restDsClient.Headers.Add("x-auth-token",token)
-	restDsClient.Headers.Add("Content-Type","application/json")
-	fmt.Printf("/")
-	for {
-		rBytes:=request.Bytes()
-		response := restDsClient.Post(url,rBytes)
-
-		if response.Err != nil{
-			fmt.Println("LAST SCROLL:")
-			fmt.Println(request.Path("scroll_id").Data().(string))
-			panic(response.Err)
-		}
-		if response.StatusCode != http.StatusOK  {
-			panic(response)
-		}
+// requestPacer paces every DS request of the process, nil when there is no
+// requests per minute budget.
+var requestPacer *ds.Pacer
 
-		responseParsed, err := gabs.ParseJSON(response.Bytes())
-		check(err)
+// debugDumps makes every DS response be dumped to stderr.
+var debugDumps bool
 
-		children, _ := responseParsed.S("documents").Children()
+// prefetchPages is how many pages are fetched ahead of the one being
+// written, 0 to only ask for a page once the one before is written, as a
+// checkpoint needs.
+var prefetchPages int
 
-		if children == nil || len(children)==0{
-			return
-		}
-		request.Set(responseParsed.Path("scroll_id").Data().(string),"scroll_id")
-		request.Delete("size")
+// retryPolicy and requestTimeout apply to every DS request of the process.
+var (
+	retryPolicy    = ds.DefaultRetryPolicy
+	requestTimeout time.Duration
+)
 
-		callback(children)
-		fmt.Printf("*")
-		time.Sleep(time.Duration(sleep) * time.Millisecond)
+// addRequestFlags adds the flags of how DS requests are sent.
+func addRequestFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&requestTimeout, "timeout", 0, "timeout of every DS request, retried when reached (default: none)")
+	fs.IntVar(&retryPolicy.MaxRetries, "retries", retryPolicy.MaxRetries, "times a page is asked again after a 5xx, 429 or timeout")
+	fs.BoolVar(&debugDumps, "debug", false, "dump every DS request and response to stderr, token redacted")
+}
 
+// newPacer returns the pacer of a -rpm budget, printing its rate changes.
+func newPacer(rpm uint64) *ds.Pacer {
+	p := ds.NewPacer(rpm)
+	p.OnChange = func(from uint64, to uint64, reason string) {
+		fmt.Fprintf(os.Stderr, "\nrate %d -> %d requests/min (%s)\n", from, to, reason)
 	}
-	fmt.Printf("/")
+	return p
+}
 
+// dsConfig is the scroller configuration of the commands, recording every
+// response in the metrics and the -debug dumps.
+func dsConfig(url string, token string, sleep int) ds.Config {
+	return ds.Config{
+		URL:           url,
+		Token:         token,
+		Sleep:         time.Duration(sleep) * time.Millisecond,
+		Pacer:         requestPacer,
+		Retry:         &retryPolicy,
+		Timeout:       requestTimeout,
+		MetricsTarget: "ds-scroller",
+		// Ids over 2^53 and decimals are written as DS sent them.
+		UseNumber: true,
+		OnResponse: func(response *rest.Response, latency time.Duration) {
+			if response.Err != nil {
+				recordResponse(0, latency)
+				return
+			}
+			recordResponse(response.StatusCode, latency)
+			dumpResponse(response)
+		},
+		OnRetry: func(reason string, delay time.Duration) {
+			exportMetrics.RecordSimpleMetric(metricPageRetry, 1, "reason:"+reason)
+		},
+		Seen:    seenIDs,
+		IDField: idField,
+		OnDuplicate: func(docs []*gabs.Container) {
+			exportMetrics.RecordSimpleMetric(metricPageDuplicate, 1)
+		},
+		OnDropped: func(doc *gabs.Container) {
+			exportMetrics.RecordSimpleMetric(metricDocsDropped, 1)
+		},
+	}
 }
 
+// process scrolls request page by page, handing every page to callback,
+// until DS returns an empty page. The next pages are fetched while callback
+// handles one, up to prefetchPages of them. It stops at the first error,
+// which is one of the *Error types of the ds package.
+func process(ctx context.Context, url string, token string, request *gabs.Container, sleep int, callback func(page *ds.Page) error) error {
+	s := ds.NewScroller(dsConfig(url, token, sleep), request)
+	next := func() (*ds.Page, error) {
+		docs, err := s.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &ds.Page{Documents: docs, Number: s.Page(), ScrollID: s.ScrollID()}, nil
+	}
+	if prefetchPages > 0 {
+		st := s.Stream(ctx, prefetchPages)
+		defer st.Close()
+		next = st.Next
+	}
 
-func init() {
-	customPool := &rest.CustomPool{
-		MaxIdleConnsPerHost: 4,
+	first := true
+	for {
+		page, err := next()
+		if first {
+			if total, ok := s.Total(); ok {
+				exportProgress.expect(total)
+			}
+			first = false
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		exportMetrics.RecordCompoundMetric(metricPageDocuments, float64(len(page.Documents)))
+		if err := callback(page); err != nil {
+			return &ds.CallbackError{Page: page.Number, Err: err}
+		}
+		exportProgress.page(len(page.Documents))
 	}
+}
+
+// search sends a single, non scroll, request and returns the parsed
+// response.
+func search(ctx context.Context, url string, token string, request *gabs.Container) (*gabs.Container, error) {
+	return ds.Search(ctx, dsConfig(url, token, 0), request)
+}
 
-	restDsClient = &rest.RequestBuilder{
-		Timeout:        100*time.Second,
-		ContentType:    rest.BYTES,
-		DisableTimeout: true,
-		EnableCache:    false,
-		CustomPool:     customPool,
-		RetryStrategy:  retry.NewSimpleRetryStrategy(3, 1000*time.Millisecond),
+// dumpResponse prints the request and response on the wire to stderr when
+// -debug is set, with the token redacted.
+func dumpResponse(response *rest.Response) {
+	if !debugDumps || response.Request == nil || response.Response == nil {
+		return
 	}
+	fmt.Fprint(os.Stderr, redact(response.Debug()))
 }
@@ -0,0 +1,535 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/gabs"
+	"github.com/aranajuanm/dsScroller/ds"
+)
+
+// exportOptions are the flags of the export command.
+type exportOptions struct {
+	url        string
+	token      string
+	out        string
+	outDir     string
+	format     string
+	compress   string
+	rotateRows int64
+	rotateSize byteSize
+	columnSpec string
+	header     bool
+	table      string
+	indexes    string
+	overwrite  bool
+	types      string
+	rowGroup   int64
+	size       int
+	sleep      int
+	prefetch   int
+	resume     bool
+	rpm        uint64
+	dryRun     bool
+	progress   time.Duration
+	metrics    string
+	dedupe     string
+	idField    string
+	dedupeMax  int
+
+	window      string
+	windowField string
+	windowTZ    string
+
+	slices     int
+	workers    int
+	sliceBy    string
+	sliceField string
+	ordered    bool
+}
+
+func runExport(ctx context.Context, args []string) error {
+	var o exportOptions
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.StringVar(&o.url, "url", "", "DS search URL of the read proxy (required)")
+	tokens := addTokenFlags(fs)
+	query := addQueryFlags(fs)
+	fs.StringVar(&o.out, "out", "", "output file (default: export.<format>[.gz|.zst])")
+	fs.StringVar(&o.outDir, "out-dir", "", "directory -out is relative to (default: the current one)")
+	fs.StringVar(&o.format, "format", "csv", "output format: csv, jsonl, sqlite or parquet")
+	fs.StringVar(&o.compress, "compress", "", "compress the output, or the pages of parquet: gzip or zstd")
+	fs.Int64Var(&o.rotateRows, "rotate-rows", 0, "start a new numbered output file every this many documents")
+	fs.Var(&o.rotateSize, "rotate-size", "start a new numbered output file once one reaches this size, e.g. 512M or 2G")
+	fs.StringVar(&o.columnSpec, "columns", "", "columns as path[:format[:default]],... (default: the query projections for csv, whole documents for jsonl, either for sqlite and parquet)")
+	fs.BoolVar(&o.header, "header", true, "write a header row with the column paths (csv)")
+	fs.StringVar(&o.table, "table", "documents", "table to write the documents to (sqlite)")
+	fs.StringVar(&o.indexes, "index", "", "comma separated columns to index once the export is done (sqlite)")
+	fs.BoolVar(&o.overwrite, "overwrite", false, "replace the -out database if it exists (sqlite)")
+	fs.StringVar(&o.types, "types", "", "types of fields as path:type,..., with string, boolean, int64, double, timestamp or json (parquet)")
+	fs.Int64Var(&o.rowGroup, "row-group-rows", 100000, "documents per row group (parquet)")
+	fs.IntVar(&o.size, "size", 500, "documents per scroll page")
+	fs.IntVar(&o.sleep, "sleep", 1000, "milliseconds to wait between pages")
+	fs.Uint64Var(&o.rpm, "rpm", 0, "requests per minute budget shared by all the scrolls, adapted to 429/503 and latency (0: no limit)")
+	fs.IntVar(&o.prefetch, "prefetch", 0, "pages fetched ahead of the one being written, for exports without a checkpoint: -slices, parquet or rotated")
+	fs.BoolVar(&o.resume, "resume", false, "continue the export from the checkpoint next to -out")
+	fs.IntVar(&o.slices, "slices", 1, "split the query into this many disjoint slices scrolled in parallel")
+	fs.IntVar(&o.workers, "workers", 0, "slices scrolled at the same time (default: all of them)")
+	fs.StringVar(&o.sliceBy, "slice-by", "date", "how to slice the query: date or id, which cut the date_range or range clause of the query and need one with both bounds, or server")
+	fs.StringVar(&o.sliceField, "slice-field", "", "field of the date_range or range clause to slice (default: the first one, or id)")
+	fs.BoolVar(&o.ordered, "ordered", false, "write the slices one after the other instead of interleaving their pages")
+	fs.StringVar(&o.dedupe, "dedupe", "", "drop the documents whose id was written before: memory or bloom")
+	fs.StringVar(&o.idField, "id-field", "id", "field with the id of the documents, for -dedupe")
+	fs.IntVar(&o.dedupeMax, "dedupe-max", 1000000, "ids remembered by -dedupe memory, or expected by -dedupe bloom")
+	fs.StringVar(&o.window, "window", "", "export the date_range of the query in windows of a day, week or month, each to its own file")
+	fs.StringVar(&o.windowField, "window-field", "date_created", "field of the date_range clause to cut in windows")
+	fs.StringVar(&o.windowTZ, "window-tz", "", "time zone the windows start in, e.g. -04:00 (default: the one of the date_range, or UTC)")
+	fs.DurationVar(&o.progress, "progress-interval", 30*time.Second, "time between progress lines when stderr is not a terminal")
+	fs.StringVar(&o.metrics, "metrics", "godog", "where to record metrics: godog, none, memory or file:<path>")
+	addRequestFlags(fs)
+	fs.BoolVar(&o.dryRun, "dry-run", false, "print the requests, count the documents and estimate the duration without exporting")
+	profile := addProfileFlag(fs)
+	fs.Parse(args)
+	if err := applyProfile(fs, *profile); err != nil {
+		return err
+	}
+
+	if !o.dryRun && o.url == "" {
+		return errors.New("-url is required, or a -profile with one")
+	}
+	token, err := tokens.resolve()
+	if err != nil {
+		return err
+	}
+	o.token = token
+	if !o.dryRun && o.token == "" {
+		return errors.New("a token is required: -token, -token-file, -token-command or $" + tokenEnv)
+	}
+	if o.size <= 0 {
+		return fmt.Errorf("-size must be positive, got %d", o.size)
+	}
+	if !formats[o.format] {
+		return fmt.Errorf("unknown -format %q", o.format)
+	}
+	ext, ok := compressionExts[o.compress]
+	if !ok && o.compress != "" {
+		return fmt.Errorf("unknown -compress %q", o.compress)
+	}
+	switch {
+	case o.format == "sqlite" && (ext != "" || o.rotating()):
+		return errors.New("-compress, -rotate-rows and -rotate-size are not supported with -format sqlite")
+	case o.format == "parquet" && (o.resume || o.rotating()):
+		return errors.New("-resume, -rotate-rows and -rotate-size are not supported with -format parquet")
+	case o.format == "parquet":
+		// The pages are compressed, not the file.
+		ext = ""
+	}
+	if o.prefetch < 0 {
+		return fmt.Errorf("-prefetch cannot be negative, got %d", o.prefetch)
+	}
+	// The checkpoint of a page holds the scroll_id of the next one, which
+	// a prefetch has asked for already: resuming from it would fail, or
+	// skip the pages prefetched.
+	if o.prefetch > 0 && o.slices <= 1 && o.resumable() {
+		return errors.New("-prefetch is only supported by exports without a checkpoint: -slices, -format parquet or -rotate-rows/-rotate-size")
+	}
+	prefetchPages = o.prefetch
+	if o.rowGroup <= 0 {
+		return fmt.Errorf("-row-group-rows must be positive, got %d", o.rowGroup)
+	}
+	if o.out == "" {
+		o.out = "export." + o.format
+	}
+	// Files are named after what they hold, whatever -out says.
+	if !strings.HasSuffix(o.out, ext) {
+		o.out += ext
+	}
+	if o.outDir != "" && !filepath.IsAbs(o.out) {
+		o.out = filepath.Join(o.outDir, o.out)
+	}
+
+	if o.resume && o.rotating() {
+		return errors.New("-resume is not supported with -rotate-rows or -rotate-size")
+	}
+
+	if o.rpm > 0 {
+		requestPacer = newPacer(o.rpm)
+	}
+
+	exportMetrics, err = newMetricsSink(o.metrics)
+	if err != nil {
+		return err
+	}
+	if f, ok := exportMetrics.(*fileSink); ok {
+		defer f.Close()
+	}
+
+	if o.dryRun {
+		return dryRun(ctx, o, query)
+	}
+	if o.slices > 1 && o.resume {
+		return errors.New("-resume is not supported with -slices")
+	}
+	if o.window != "" {
+		switch {
+		case !windowUnits[o.window]:
+			return fmt.Errorf("unknown -window %q, use day, week or month", o.window)
+		case o.slices > 1:
+			return errors.New("-window and -slices cannot be used together")
+		case o.dedupe == "bloom":
+			return errors.New("-dedupe bloom is not supported with -window, use -dedupe memory")
+		}
+	}
+	if err := openDedupe(o); err != nil {
+		return err
+	}
+	exportProgress = newProgress(os.Stderr, o.progress)
+
+	start := time.Now()
+	switch {
+	case o.window != "":
+		err = exportWindows(ctx, o, query)
+	case o.slices > 1:
+		err = exportSlices(ctx, o, query)
+	default:
+		var request *gabs.Container
+		if !o.resume {
+			request, err = loadScrollRequest(query, o.size)
+		}
+		if err == nil {
+			err = exportScroll(ctx, o, request)
+		}
+	}
+	resumable := err != nil && o.slices <= 1 && o.resumable()
+	if cerr := closeDedupe(o, resumable); err == nil {
+		err = cerr
+	}
+	if err == nil && o.window == "" {
+		reportMissing()
+	}
+	recordExport(start, err)
+	return err
+}
+
+// reportMissing warns when fewer documents were written than DS said the
+// query matches, or more, which were written twice.
+func reportMissing() {
+	missing, extra := exportProgress.missing(), exportProgress.extra()
+	exportMetrics.RecordSimpleMetric(metricExportMissing, float64(missing))
+	exportMetrics.RecordSimpleMetric(metricExportExtra, float64(extra))
+	switch {
+	case missing > 0:
+		fmt.Fprintf(os.Stderr, "warning: DS counted %d documents but %d were written, %d may be missing\n",
+			exportProgress.expected(), exportProgress.expected()-missing, missing)
+	case extra > 0:
+		fmt.Fprintf(os.Stderr, "warning: DS counted %d documents but %d were written, %d may be duplicates, -dedupe drops them\n",
+			exportProgress.expected(), exportProgress.expected()+extra, extra)
+	}
+}
+
+// recordExport records how long the export took and how many documents it
+// wrote, tagged with whether it finished.
+func recordExport(start time.Time, err error) {
+	result := "result:ok"
+	if err != nil {
+		result = "result:error"
+	}
+	exportMetrics.RecordFullMetric(metricExportDuration, float64(time.Since(start))/float64(time.Millisecond), result)
+	exportMetrics.RecordSimpleMetric(metricExportDocs, float64(exportProgress.documents()), result)
+}
+
+// exportScroll exports the scroll started by request, checkpointing after
+// every page. With -resume it continues the scroll of the checkpoint
+// instead, and request is not used.
+func exportScroll(ctx context.Context, o exportOptions, request *gabs.Container) error {
+	var cp *checkpoint
+	var err error
+	if o.resume {
+		if cp, err = loadCheckpoint(checkpointPath(o.out)); err != nil {
+			return err
+		}
+		if request, err = cp.resumeRequest(); err != nil {
+			return err
+		}
+	} else {
+		cp = newCheckpoint(checkpointPath(o.out), request, o.size)
+	}
+
+	output, err := o.output(request)
+	if err != nil {
+		return err
+	}
+	var w exportOutput
+	if o.resume {
+		if w, err = resumeOutput(output, cp.Offset); err != nil {
+			return err
+		}
+		fmt.Printf("resuming %s after %d pages, %d documents\n", o.out, cp.Pages, cp.Documents)
+		exportProgress.resume(int64(cp.Documents), int64(cp.Pages), cp.Total)
+		exportProgress.written(w.Bytes())
+	} else if w, err = createOutput(output); err != nil {
+		return err
+	}
+
+	for {
+		err = process(ctx, o.url, o.token, request, o.sleep, func(page *ds.Page) error {
+			if err := w.WritePage(page.Documents); err != nil {
+				return err
+			}
+			// The page is on disk before the checkpoint says it was written.
+			if err := w.Sync(); err != nil {
+				return err
+			}
+			// cp stays the one on disk until the next one is saved, so a
+			// failure says the page -resume really continues from.
+			next := *cp
+			next.advance(page, w.Offset())
+			next.Total = exportProgress.expected()
+			if err := next.save(); err != nil {
+				return err
+			}
+			*cp = next
+			exportProgress.written(w.Bytes())
+			// The ids go after the checkpoint: ids saved for a page the
+			// checkpoint doesn't have would drop it when it is asked again.
+			return syncDedupe()
+		})
+		if _, expired := err.(*ds.ScrollExpiredError); !expired {
+			break
+		}
+
+		fmt.Printf("\nscroll expired after %d pages, re-querying from the last document\n", cp.Pages)
+		exportProgress.restart()
+		if request, err = cp.cursorRequest(); err != nil {
+			w.Close()
+			return fmt.Errorf("scroll expired: %v", err)
+		}
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	exportProgress.written(w.Bytes())
+	exportProgress.finish(o.out, err)
+	if err != nil {
+		if !o.resumable() {
+			return err
+		}
+		if errors.Is(err, context.Canceled) {
+			fmt.Fprintf(os.Stderr, "rerun with -resume to continue from page %d\n", cp.Pages+1)
+			return err
+		}
+		return fmt.Errorf("%w\nrerun with -resume to continue from page %d", err, cp.Pages+1)
+	}
+
+	return cp.remove()
+}
+
+// exportSlices splits the query into o.slices disjoint slices and scrolls
+// them in parallel. Unordered, pages are written as they arrive; ordered,
+// every slice goes to its own temporary JSON Lines file and those are
+// written to the output in slice order at the end.
+func exportSlices(ctx context.Context, o exportOptions, query *queryFlags) error {
+	request, err := loadScrollRequest(query, o.size)
+	if err != nil {
+		return err
+	}
+	slices, err := sliceQuery(request, o.sliceBy, o.sliceField, o.slices)
+	if err != nil {
+		return err
+	}
+	output, err := o.output(request)
+	if err != nil {
+		return err
+	}
+	w, err := createOutput(output)
+	if err != nil {
+		return err
+	}
+
+	if !o.ordered {
+		var mu sync.Mutex
+		err = scrollSlices(ctx, o.url, o.token, slices, o.workers, o.sleep, func(slice int, docs []*gabs.Container) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if err := w.WritePage(docs); err != nil {
+				return err
+			}
+			exportProgress.written(w.Bytes())
+			return nil
+		})
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		exportProgress.written(w.Bytes())
+		exportProgress.finish(o.out, err)
+		return err
+	}
+
+	err = scrollOrdered(ctx, o, slices, w)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	exportProgress.written(w.Bytes())
+	exportProgress.finish(o.out, err)
+	return err
+}
+
+func scrollOrdered(ctx context.Context, o exportOptions, slices []*gabs.Container, w documentWriter) error {
+	dir, err := ioutil.TempDir(filepath.Dir(o.out), filepath.Base(o.out)+".parts")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	parts := make([]documentWriter, len(slices))
+	names := make([]string, len(slices))
+	for i := range slices {
+		names[i] = filepath.Join(dir, fmt.Sprintf("part-%04d.jsonl", i))
+		f, err := os.Create(names[i])
+		if err != nil {
+			closeAll(parts)
+			return err
+		}
+		parts[i] = newJSONLWriter(f, nil)
+	}
+
+	err = scrollSlices(ctx, o.url, o.token, slices, o.workers, o.sleep, func(slice int, docs []*gabs.Container) error {
+		return parts[slice].WritePage(docs)
+	})
+	if cerr := closeAll(parts); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if err := replayJSONL(w, name, o.size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadScrollRequest loads the query of the command line and turns it into
+// the first request of a scroll.
+func loadScrollRequest(query *queryFlags, size int) (*gabs.Container, error) {
+	request, err := query.load()
+	if err != nil {
+		return nil, err
+	}
+	request.Set("scroll", "type")
+	request.Set(size, "size")
+	return request, nil
+}
+
+// columns are the columns given with -columns or, for csv, the ones of the
+// query projections. sqlite uses the projections when there are some.
+func (o exportOptions) columns(request *gabs.Container) ([]column, error) {
+	if o.columnSpec != "" {
+		return parseColumns(o.columnSpec)
+	}
+	switch o.format {
+	case "csv":
+		return projectionColumns(request)
+	case "sqlite", "parquet":
+		if request.Exists("projections") {
+			return projectionColumns(request)
+		}
+	}
+	return nil, nil
+}
+
+func closeAll(writers []documentWriter) error {
+	var first error
+	for _, w := range writers {
+		if w == nil {
+			continue
+		}
+		if err := w.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (o exportOptions) rotating() bool {
+	return o.rotateRows > 0 || o.rotateSize > 0
+}
+
+// resumable tells whether a failed export can be picked up with -resume.
+func (o exportOptions) resumable() bool {
+	return !o.rotating() && o.format != "parquet"
+}
+
+// output describes the files the export of request is written to.
+func (o exportOptions) output(request *gabs.Container) (outputOptions, error) {
+	columns, err := o.columns(request)
+	if err != nil {
+		return outputOptions{}, err
+	}
+	types, err := parseTypes(o.types)
+	if err != nil {
+		return outputOptions{}, err
+	}
+	return outputOptions{
+		path:         o.out,
+		format:       o.format,
+		columns:      columns,
+		header:       o.header,
+		compress:     o.compress,
+		maxRows:      o.rotateRows,
+		maxBytes:     int64(o.rotateSize),
+		table:        o.table,
+		indexes:      splitList(o.indexes),
+		overwrite:    o.overwrite,
+		types:        types,
+		rowGroupRows: o.rowGroup,
+	}, nil
+}
+
+// byteSize is a flag holding a size in bytes written as 100, 64K, 512M or 2G.
+type byteSize int64
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(value string) error {
+	units := map[string]int64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+	size := strings.TrimSuffix(strings.ToUpper(value), "B")
+	if size == "" {
+		return errors.New("empty size")
+	}
+	unit := int64(1)
+	if u, ok := units[size[len(size)-1:]]; ok && len(size) > 1 {
+		unit = u
+		size = size[:len(size)-1]
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/unit {
+		return fmt.Errorf("invalid size %q", value)
+	}
+	*b = byteSize(n * unit)
+	return nil
+}
+
+// splitList splits a comma separated flag value, dropping empty entries.
+func splitList(value string) []string {
+	var list []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aranajuanm/dsScroller/ds"
+	"github.com/aranajuanm/dsScroller/ds/dstest"
+	"github.com/aranajuanm/dsScroller/internal/testflags"
+)
+
+func TestMain(m *testing.M) {
+	testflags.Parse()
+	retryPolicy = ds.RetryPolicy{MaxRetries: 3, MinDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	os.Exit(m.Run())
+}
+
+const testQuery = `{"query":{"and":[{"exists":{"field":"id"}}]},"sort":{"field":"id","order":"asc"}}`
+
+// testExport runs the export command against srv with -metrics memory,
+// writing to dir, and returns the metrics it recorded.
+func testExport(t *testing.T, srv *dstest.Server, dir string, args ...string) (*memorySink, error) {
+	t.Helper()
+	query := filepath.Join(dir, "query.json")
+	if err := ioutil.WriteFile(query, []byte(testQuery), 0644); err != nil {
+		t.Fatal(err)
+	}
+	args = append([]string{
+		"-url", srv.URL, "-token", "xyzzy", "-query", query, "-out-dir", dir,
+		"-size", "10", "-sleep", "0", "-metrics", "memory",
+	}, args...)
+	err := runExport(context.Background(), args)
+	sink, ok := exportMetrics.(*memorySink)
+	if !ok {
+		t.Fatalf("exportMetrics is %T, want *memorySink", exportMetrics)
+	}
+	return sink, err
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "dsscroller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n := 0
+	for s := bufio.NewScanner(f); s.Scan(); n++ {
+	}
+	return n
+}
+
+func TestExportMetrics(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	srv := dstest.NewServer(dstest.Documents(25))
+	defer srv.Close()
+	srv.Fail(2, 503, 1)
+	srv.Repeat(3, 1)
+
+	m, err := testExport(t, srv, dir, "-format", "jsonl", "-out", "out.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countLines(t, filepath.Join(dir, "out.jsonl")); n != 25 {
+		t.Errorf("wrote %d documents, want 25", n)
+	}
+	for _, c := range []struct {
+		name string
+		want float64
+	}{
+		{metricExportDocs, 25},
+		{metricOutputRows, 25},
+		{metricPageRetry, 1},
+		{metricPageDuplicate, 1},
+		{metricExportMissing, 0},
+		{metricExportExtra, 0},
+	} {
+		if got := m.Sum(c.name); got != c.want {
+			t.Errorf("%s = %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	docs := m.Records(metricExportDocs)
+	if len(docs) != 1 || len(docs[0].Tags) != 1 || docs[0].Tags[0] != "result:ok" {
+		t.Errorf("%s records = %+v, want one tagged result:ok", metricExportDocs, docs)
+	}
+	// The three pages with documents, the failed and the repeated ones, and
+	// the empty one that ends the scroll.
+	if n := len(m.Records(metricPageStatus)); n != 6 {
+		t.Errorf("%d %s records, want 6", n, metricPageStatus)
+	}
+	if retries := m.Records(metricPageRetry); len(retries) == 1 && retries[0].Tags[0] != "reason:503" {
+		t.Errorf("retry tagged %v, want reason:503", retries[0].Tags)
+	}
+}
+
+func TestNewMetricsSink(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	for _, c := range []struct {
+		spec string
+		want interface{}
+	}{
+		{"godog", godogSink{}},
+		{"none", noMetrics{}},
+		{"memory", &memorySink{}},
+		{"file:" + filepath.Join(dir, "metrics.jsonl"), &fileSink{}},
+		{"datadog", nil},
+		{"file", nil},
+	} {
+		sink, err := newMetricsSink(c.spec)
+		if c.want == nil {
+			if err == nil {
+				t.Errorf("newMetricsSink(%q) = %T, want an error", c.spec, sink)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("newMetricsSink(%q): %v", c.spec, err)
+			continue
+		}
+		if f, ok := sink.(*fileSink); ok {
+			f.Close()
+		}
+		if got, want := fmt.Sprintf("%T", sink), fmt.Sprintf("%T", c.want); got != want {
+			t.Errorf("newMetricsSink(%q) = %s, want %s", c.spec, got, want)
+		}
+	}
+}
+
+func TestExportResume(t *testing.T) {
+	// Ids above 2^53 must keep their digits through the checkpoint, or the
+	// range that resumes an expired scroll would repeat or skip documents:
+	// the 20th id from 9007199254740994 is odd, which a float64 can't hold.
+	for _, first := range []int64{1, 9007199254740994} {
+		testExportResume(t, first)
+	}
+}
+
+func testExportResume(t *testing.T, first int64) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out.jsonl")
+	cp := checkpointPath(out)
+
+	docs := dstest.Documents(25)
+	for i, doc := range docs {
+		doc["id"] = json.Number(strconv.FormatInt(first+int64(i), 10))
+	}
+
+	// Once page 3 is asked for, the checkpoint can't be saved: its page is
+	// written and the callback fails.
+	srv := dstest.NewHandler(docs)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(srv.Requests()) == 2 {
+			if err := os.Rename(cp, cp+".saved"); err != nil {
+				t.Error(err)
+			}
+			if err := os.MkdirAll(filepath.Join(cp, "in-the-way"), 0755); err != nil {
+				t.Error(err)
+			}
+		}
+		srv.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	srv.URL = ts.URL
+
+	_, err := testExport(t, srv, dir, "-format", "jsonl", "-out", "out.jsonl")
+	var callback *ds.CallbackError
+	if !errors.As(err, &callback) || callback.Page != 3 {
+		t.Fatalf("ids from %d: got %v, want a *ds.CallbackError on page 3", first, err)
+	}
+	if n := countLines(t, out); n != 25 {
+		t.Fatalf("ids from %d: wrote %d documents before failing, want 25", first, n)
+	}
+
+	if err := os.RemoveAll(cp); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(cp+".saved", cp); err != nil {
+		t.Fatal(err)
+	}
+	m, err := testExport(t, srv, dir, "-format", "jsonl", "-out", "out.jsonl", "-resume")
+	if err != nil {
+		t.Fatalf("ids from %d: %v", first, err)
+	}
+
+	// The scroll of the checkpoint expired, so the export went on from
+	// the last id written, 20th of the 25.
+	requests := srv.Requests()
+	last := requests[len(requests)-2]
+	if want := fmt.Sprintf(`{"field":"id","gt":%d}`, first+19); !strings.Contains(last.String(), want) {
+		t.Errorf("ids from %d: resumed with %s, want a range %s", first, last, want)
+	}
+
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != 25 {
+		t.Fatalf("ids from %d: resumed export has %d documents, want 25", first, len(lines))
+	}
+	for i, line := range lines {
+		var doc struct{ ID int64 }
+		if err := json.Unmarshal([]byte(line), &doc); err != nil || doc.ID != first+int64(i) {
+			t.Fatalf("line %d is %s, want the document with id %d", i+1, line, first+int64(i))
+		}
+	}
+	// The metric counts the documents of this run only.
+	if got := m.Sum(metricExportDocs); got != 5 {
+		t.Errorf("%s = %v, want 5", metricExportDocs, got)
+	}
+	if _, err := os.Stat(cp); !os.IsNotExist(err) {
+		t.Errorf("checkpoint left after the export: %v", err)
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	for _, c := range []struct {
+		value string
+		want  byteSize
+		err   bool
+	}{
+		{value: "0", want: 0},
+		{value: "1000", want: 1000},
+		{value: "512K", want: 512 << 10},
+		{value: "512kb", want: 512 << 10},
+		{value: "2G", want: 2 << 30},
+		{value: "1T", want: 1 << 40},
+		{value: "100B", want: 100},
+		{value: "K", err: true},
+		{value: "", err: true},
+		{value: "-1M", err: true},
+		{value: "1.5G", err: true},
+		{value: "2P", err: true},
+		{value: "9999999999T", err: true},
+	} {
+		var b byteSize
+		err := b.Set(c.value)
+		if c.err {
+			if err == nil {
+				t.Errorf("Set(%q) = %d, want an error", c.value, b)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Set(%q): %v", c.value, err)
+		} else if b != c.want {
+			t.Errorf("Set(%q) = %d, want %d", c.value, b, c.want)
+		}
+	}
+}
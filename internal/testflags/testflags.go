@@ -0,0 +1,24 @@
+// Package testflags hides the flags of a test binary from the vendored rest
+// package, whose init parses the command line and exits on the -test flags
+// it doesn't know. Tests of packages importing rest import it for its
+// init, which runs before that of rest, and call Parse from TestMain.
+package testflags
+
+import (
+	"flag"
+	"os"
+)
+
+var args []string
+
+func init() {
+	args = os.Args
+	os.Args = os.Args[:1]
+}
+
+// Parse gives the test binary its flags back and parses them. Call it
+// before m.Run.
+func Parse() {
+	os.Args = args
+	flag.Parse()
+}
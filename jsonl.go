@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/Jeffail/gabs"
+)
+
+// jsonlWriter writes every document as one compact JSON object per line.
+// With columns, only those paths are kept, at the same place in the object.
+type jsonlWriter struct {
+	w       *bufio.Writer
+	c       io.Closer
+	columns []column
+}
+
+func newJSONLWriter(w io.WriteCloser, columns []column) *jsonlWriter {
+	return &jsonlWriter{w: bufio.NewWriter(w), c: w, columns: columns}
+}
+
+func (jw *jsonlWriter) WritePage(docs []*gabs.Container) error {
+	for _, doc := range docs {
+		if len(jw.columns) > 0 {
+			doc = jw.project(doc)
+		}
+		if _, err := jw.w.Write(doc.Bytes()); err != nil {
+			return err
+		}
+		if err := jw.w.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return jw.w.Flush()
+}
+
+// project copies the column paths of doc into a new document. Missing or
+// null fields are left out unless the column has a default.
+func (jw *jsonlWriter) project(doc *gabs.Container) *gabs.Container {
+	projected := gabs.New()
+	for _, c := range jw.columns {
+		value := doc.Path(c.Path).Data()
+		if value == nil {
+			if c.Default == "" {
+				continue
+			}
+			value = c.Default
+		}
+		projected.SetP(value, c.Path)
+	}
+	return projected
+}
+
+func (jw *jsonlWriter) Close() error {
+	if err := jw.w.Flush(); err != nil {
+		jw.c.Close()
+		return err
+	}
+	return jw.c.Close()
+}
@@ -1,69 +1,131 @@
 package main
 
 import (
-	"github.com/Jeffail/gabs"
-	"os"
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"sort"
+	"syscall"
 )
 
-
-func main() {
-	token := "TOKEN_FURY"
-	url := "https://read-services-proxy.furycloud.io/applications/mpcs-movements/ds/services/ds-movements-v1/search"
-	size:= 500
-	sleep:=1000
-	body := `
-{
-    "query": {
-      "and":[
-     
-        {"date_range": { "field": "date_created", "gt": "2019-01-01", "lt": "2019-02-20", "format": "YYYY-MM-dd", "time_zone": "-04:00" }},
-        {"eq": {
-            "field": "status",
-            "value": "unavailable"
-        }},
-        {"not":{"exists": {"field": "date_released"}}}
-      ]
-        
-    },
-	"projections": ["id"],
-    "type": "scroll",
-    "secondary_search":true,
-    "size": 10
+// command is a dsScroller subcommand. Each one parses its own flags from
+// the arguments that follow the command name.
+//
+// Global flags are not supported on purpose: the vendored rest package
+// calls flag.Parse() from its init, so anything placed before the command
+// name would be rejected there. Parsing stops at the first non-flag
+// argument, which is why `dsScroller <command> -flags...` works.
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string) error
 }
-`
-	fileName:="export.csv"
 
-	file, err := os.Create(fileName)
-	check(err)
-	defer file.Close()
+// exitInterrupted is the exit status of a command stopped by SIGINT or
+// SIGTERM, as a shell reports a process killed by SIGINT.
+const exitInterrupted = 130
 
-	jsonParsed, err := gabs.ParseJSON([]byte(body))
-	check(err)
+var commands = map[string]command{
+	"count": {
+		usage: "print how many documents a query matches",
+		run:   runCount,
+	},
+	"export": {
+		usage: "scroll a query and dump the documents to a file",
+		run:   runExport,
+	},
+	"mock": {
+		usage: "serve a fake DS search service to try exports against",
+		run:   runMock,
+	},
+	"validate": {
+		usage: "check that a query file is valid JSON and can be scrolled",
+		run:   runValidate,
+	},
+}
 
-	jsonParsed.Set("scroll","type")
-	jsonParsed.Set(size,"size")
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
 
-	process(url,token,jsonParsed, sleep, func(response []*gabs.Container) error{
-		idList := ""
-		for _, child := range response {
-			msj := fmt.Sprintf(`%.0f,MLM`,
-				child.Path("id").Data().(float64),
-			)
-			idList = idList + msj + "\n"
-		}
-		_,err := file.WriteString(idList)
-		check(err)
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "--help" {
+		usage()
+		return
+	}
 
-		return nil
-	})
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "dsScroller: unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
 
+	defer redactPanic()
+	ctx, stop := interruptible()
+	err := cmd.run(ctx, os.Args[2:])
+	stop()
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintf(os.Stderr, "dsScroller %s: interrupted\n", name)
+		os.Exit(exitInterrupted)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dsScroller %s: %s\n", name, redact(err.Error()))
+		os.Exit(1)
+	}
+}
 
+// interruptible returns a context cancelled by the first SIGINT or SIGTERM,
+// which lets the command finish its current page and stop cleanly. A second
+// one kills the process as usual.
+func interruptible() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			signal.Stop(signals)
+			fmt.Fprintf(os.Stderr, "\n%v, stopping after the current page (again to quit at once)\n", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
 
+// redactPanic prints a panic and its stack with the token redacted, instead
+// of letting the runtime print them as they are.
+func redactPanic() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "panic: %s\n\n%s", redact(fmt.Sprint(r)), redact(string(debug.Stack())))
+	os.Exit(2)
 }
 
-func check(e error) {
-	if e != nil {
-		panic(e)
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: dsScroller <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
 	}
-}
\ No newline at end of file
+
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run 'dsScroller <command> -h' for the flags of each command.")
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mercadolibre/go-meli-toolkit/godog"
+)
+
+const (
+	metricPageLatency    = "dsscroller.page.latency"
+	metricPageStatus     = "dsscroller.page.status"
+	metricPageDocuments  = "dsscroller.page.documents"
+	metricPageRetry      = "dsscroller.page.retry"
+	metricPageDuplicate  = "dsscroller.page.duplicate"
+	metricOutputBytes    = "dsscroller.output.bytes"
+	metricOutputRows     = "dsscroller.output.rows"
+	metricOutputInvalid  = "dsscroller.output.invalid"
+	metricExportDuration = "dsscroller.export.duration"
+	metricExportDocs     = "dsscroller.export.documents"
+	metricExportMissing  = "dsscroller.export.missing"
+	metricExportExtra    = "dsscroller.export.extra"
+	metricDocsDropped    = "dsscroller.documents.dropped"
+)
+
+// exportMetrics receives the metrics of the scrolls and the output writers.
+var exportMetrics metricsSink = godogSink{}
+
+// metricsSink is the part of godog.Client the exporter records with. It is
+// its own interface because godog.Client cannot be implemented outside
+// godog, and local sinks are needed on hosts without a metrics agent.
+type metricsSink interface {
+	RecordSimpleMetric(metricName string, value float64, tags ...string)
+	RecordCompoundMetric(metricName string, value float64, tags ...string)
+	RecordFullMetric(metricName string, value float64, tags ...string)
+}
+
+// newMetricsSink parses -metrics: godog, none, memory or file:<path>.
+func newMetricsSink(spec string) (metricsSink, error) {
+	switch {
+	case spec == "godog":
+		return godogSink{}, nil
+	case spec == "none":
+		return noMetrics{}, nil
+	case spec == "memory":
+		return &memorySink{}, nil
+	case strings.HasPrefix(spec, "file:"):
+		return newFileSink(strings.TrimPrefix(spec, "file:"))
+	}
+	return nil, fmt.Errorf("unknown -metrics %q, use godog, none, memory or file:<path>", spec)
+}
+
+// recordResponse records the latency and status of a DS request. status is
+// 0 when the request got no response.
+func recordResponse(status int, latency time.Duration) {
+	tag := godog.GetRawTag("status", "error")
+	if status != 0 {
+		tag = godog.GetRawTag("status", strconv.Itoa(status))
+	}
+	exportMetrics.RecordCompoundMetric(metricPageLatency, float64(latency)/float64(time.Millisecond), tag)
+	exportMetrics.RecordSimpleMetric(metricPageStatus, 1, tag)
+}
+
+// godogSink records through the godog package, which sends to datadog or
+// dumps for the metrics agent depending on the host.
+type godogSink struct{}
+
+func (godogSink) RecordSimpleMetric(metricName string, value float64, tags ...string) {
+	godog.RecordSimpleMetric(metricName, value, tags...)
+}
+
+func (godogSink) RecordCompoundMetric(metricName string, value float64, tags ...string) {
+	godog.RecordCompoundMetric(metricName, value, tags...)
+}
+
+func (godogSink) RecordFullMetric(metricName string, value float64, tags ...string) {
+	godog.RecordFullMetric(metricName, value, tags...)
+}
+
+type noMetrics struct{}
+
+func (noMetrics) RecordSimpleMetric(string, float64, ...string)   {}
+func (noMetrics) RecordCompoundMetric(string, float64, ...string) {}
+func (noMetrics) RecordFullMetric(string, float64, ...string)     {}
+
+// metricRecord is one recorded value. Class is S, C or F as in godog.
+type metricRecord struct {
+	Time  time.Time `json:"time"`
+	Class string    `json:"class"`
+	Name  string    `json:"name"`
+	Value float64   `json:"value"`
+	Tags  []string  `json:"tags,omitempty"`
+}
+
+// memorySink keeps every value recorded, so they can be asserted on.
+type memorySink struct {
+	mu      sync.Mutex
+	records []metricRecord
+}
+
+func (m *memorySink) RecordSimpleMetric(metricName string, value float64, tags ...string) {
+	m.record("S", metricName, value, tags)
+}
+
+func (m *memorySink) RecordCompoundMetric(metricName string, value float64, tags ...string) {
+	m.record("C", metricName, value, tags)
+}
+
+func (m *memorySink) RecordFullMetric(metricName string, value float64, tags ...string) {
+	m.record("F", metricName, value, tags)
+}
+
+func (m *memorySink) record(class string, name string, value float64, tags []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records = append(m.records, metricRecord{Time: time.Now(), Class: class, Name: name, Value: value, Tags: tags})
+}
+
+// Records returns the values recorded for metricName, in order.
+func (m *memorySink) Records(metricName string) []metricRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var records []metricRecord
+	for _, r := range m.records {
+		if r.Name == metricName {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// Sum adds up the values recorded for metricName.
+func (m *memorySink) Sum(metricName string) float64 {
+	sum := 0.0
+	for _, r := range m.Records(metricName) {
+		sum += r.Value
+	}
+	return sum
+}
+
+// fileSink appends every value recorded to a file, one JSON object per line.
+type fileSink struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+func newFileSink(path string) (*fileSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+func (f *fileSink) RecordSimpleMetric(metricName string, value float64, tags ...string) {
+	f.record("S", metricName, value, tags)
+}
+
+func (f *fileSink) RecordCompoundMetric(metricName string, value float64, tags ...string) {
+	f.record("C", metricName, value, tags)
+}
+
+func (f *fileSink) RecordFullMetric(metricName string, value float64, tags ...string) {
+	f.record("F", metricName, value, tags)
+}
+
+func (f *fileSink) record(class string, name string, value float64, tags []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Metrics are best effort, a full disk shows up in the export itself.
+	f.enc.Encode(metricRecord{Time: time.Now(), Class: class, Name: name, Value: value, Tags: tags})
+}
+
+func (f *fileSink) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aranajuanm/dsScroller/ds/dstest"
+)
+
+func runMock(ctx context.Context, args []string) error {
+	var fails, throttles, expires, repeats listFlag
+	fs := flag.NewFlagSet("mock", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	docs := fs.Int("docs", 1000, "documents to serve")
+	token := fs.String("token", "", "only x-auth-token accepted, any when empty")
+	latency := fs.Duration("latency", 0, "time to wait before answering every request")
+	fs.Var(&fails, "fail", "answer page:status[:times] with status, once by default (repeatable)")
+	fs.Var(&throttles, "throttle", "answer page[:times] with 429, once by default (repeatable)")
+	fs.Var(&expires, "expire", "expire the scroll when asked for this page (repeatable)")
+	fs.Var(&repeats, "repeat", "answer this page with the documents of the one before (repeatable)")
+	fs.Parse(args)
+
+	srv := dstest.NewHandler(dstest.Documents(*docs))
+	srv.Token = *token
+	srv.Latency = *latency
+	for _, f := range fails {
+		n, err := parseFault(f, 3)
+		if err != nil {
+			return fmt.Errorf("-fail %s: %v", f, err)
+		}
+		srv.Fail(n[0], n[1], n[2])
+	}
+	for _, t := range throttles {
+		n, err := parseFault(t, 2)
+		if err != nil {
+			return fmt.Errorf("-throttle %s: %v", t, err)
+		}
+		srv.Throttle(n[0], n[1])
+	}
+	for _, e := range expires {
+		page, err := strconv.Atoi(e)
+		if err != nil {
+			return fmt.Errorf("-expire %s: not a page number", e)
+		}
+		srv.Expire(page)
+	}
+	for _, r := range repeats {
+		page, err := strconv.Atoi(r)
+		if err != nil {
+			return fmt.Errorf("-repeat %s: not a page number", r)
+		}
+		srv.Repeat(page, 1)
+	}
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: srv}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+
+	fmt.Printf("serving %d documents on http://%s/search\n", *docs, l.Addr())
+	if err := server.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// parseFault parses colon separated numbers, the last one being how many
+// times the fault happens and 1 when missing.
+func parseFault(value string, fields int) ([]int, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) < fields-1 || len(parts) > fields {
+		return nil, fmt.Errorf("want %d or %d numbers separated by colons", fields-1, fields)
+	}
+	n := make([]int, fields)
+	n[fields-1] = 1
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", p)
+		}
+		n[i] = v
+	}
+	return n, nil
+}
+
+// listFlag collects the values of a repeated flag.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
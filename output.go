@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Jeffail/gabs"
+	"github.com/aranajuanm/dsScroller/parquet"
+)
+
+// documentWriter receives the documents of every scroll page and turns
+// them into an output file.
+type documentWriter interface {
+	WritePage(docs []*gabs.Container) error
+	Close() error
+}
+
+// exportOutput is what an export writes its pages to, and how far it got.
+type exportOutput interface {
+	documentWriter
+	// Bytes is how much has been written so far.
+	Bytes() int64
+	// Offset is what resuming needs to drop whatever the checkpoint
+	// doesn't have.
+	Offset() int64
+	// Sync makes what has been written survive a crash of the host, before
+	// the checkpoint says it was written.
+	Sync() error
+}
+
+// formats are the output formats of an export.
+var formats = map[string]bool{"csv": true, "jsonl": true, "sqlite": true, "parquet": true}
+
+// newDocumentWriter returns the writer for an output format. csv needs
+// columns, jsonl writes whole documents unless columns are given.
+func newDocumentWriter(format string, w io.WriteCloser, columns []column, header bool) (documentWriter, error) {
+	switch format {
+	case "csv":
+		return newCSVWriter(w, columns, header)
+	case "jsonl":
+		return newJSONLWriter(w, columns), nil
+	case "sqlite", "parquet":
+		return nil, fmt.Errorf("%s is written to a file of its own, not a stream", format)
+	}
+	return nil, fmt.Errorf("unknown output format %q, use csv or jsonl", format)
+}
+
+// outputOptions describe the file, or files, an export is written to.
+type outputOptions struct {
+	path     string
+	format   string
+	columns  []column
+	header   bool
+	compress string
+	// maxRows and maxBytes start a new part once reached, 0 means no limit.
+	maxRows  int64
+	maxBytes int64
+	// table and indexes are the table of a sqlite export and the columns
+	// to index, and overwrite replaces its database if there is one.
+	table     string
+	indexes   []string
+	overwrite bool
+	// types pins the type of parquet fields, and rowGroupRows is how many
+	// documents go in every row group.
+	types        map[string]parquet.Type
+	rowGroupRows int64
+}
+
+func (o outputOptions) rotating() bool {
+	return o.maxRows > 0 || o.maxBytes > 0
+}
+
+// outputWriter is the documentWriter of an export. It writes path, or
+// numbered parts of it when rotating, compressing them if asked to, and
+// lists the parts in a manifest when done.
+type outputWriter struct {
+	o     outputOptions
+	part  *partFile
+	w     documentWriter
+	rows  int64
+	parts []manifestPart
+}
+
+type manifestPart struct {
+	File   string `json:"file"`
+	Rows   int64  `json:"rows"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+type manifest struct {
+	Format      string         `json:"format"`
+	Compression string         `json:"compression,omitempty"`
+	Rows        int64          `json:"rows"`
+	Parts       []manifestPart `json:"parts"`
+}
+
+func createOutput(o outputOptions) (exportOutput, error) {
+	switch o.format {
+	case "sqlite":
+		return createSQLite(o)
+	case "parquet":
+		return createParquet(o)
+	}
+	ow := &outputWriter{o: o}
+	if err := ow.openPart(0); err != nil {
+		return nil, err
+	}
+	return ow, nil
+}
+
+// resumeOutput reopens the output of an interrupted export, dropping
+// whatever was written after offset.
+func resumeOutput(o outputOptions, offset int64) (exportOutput, error) {
+	if o.rotating() {
+		return nil, fmt.Errorf("-resume is not supported with rotated output")
+	}
+	switch o.format {
+	case "sqlite":
+		return resumeSQLite(o, offset)
+	case "parquet":
+		return nil, fmt.Errorf("-resume is not supported with parquet output")
+	}
+	part, err := reopenPart(o.path, o.compress, offset)
+	if err != nil {
+		return nil, err
+	}
+	w, err := newDocumentWriter(o.format, part, o.columns, false)
+	if err != nil {
+		part.Close()
+		return nil, err
+	}
+	return &outputWriter{o: o, part: part, w: w}, nil
+}
+
+func (ow *outputWriter) WritePage(docs []*gabs.Container) error {
+	before := ow.Bytes()
+	defer func() {
+		tag := "format:" + ow.o.format
+		exportMetrics.RecordSimpleMetric(metricOutputBytes, float64(ow.Bytes()-before), tag)
+	}()
+
+	for len(docs) > 0 {
+		if ow.w == nil {
+			if err := ow.openPart(len(ow.parts)); err != nil {
+				return err
+			}
+		}
+
+		n := len(docs)
+		if ow.o.maxRows > 0 && int64(n) > ow.o.maxRows-ow.rows {
+			n = int(ow.o.maxRows - ow.rows)
+		}
+		if err := ow.w.WritePage(docs[:n]); err != nil {
+			return err
+		}
+		if err := ow.part.endPage(); err != nil {
+			return err
+		}
+		ow.rows += int64(n)
+		exportMetrics.RecordSimpleMetric(metricOutputRows, float64(n), "format:"+ow.o.format)
+		docs = docs[n:]
+
+		if ow.full() {
+			if err := ow.closePart(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Bytes is how many bytes have been written to all the parts.
+func (ow *outputWriter) Bytes() int64 {
+	var n int64
+	for _, p := range ow.parts {
+		n += p.Bytes
+	}
+	if ow.w != nil {
+		n += ow.part.size()
+	}
+	return n
+}
+
+// Offset is the size of the current part, which only ever ends on a page
+// boundary between calls to WritePage.
+func (ow *outputWriter) Offset() int64 {
+	if ow.part == nil {
+		return 0
+	}
+	return ow.part.size()
+}
+
+func (ow *outputWriter) Sync() error {
+	if ow.w == nil {
+		return nil
+	}
+	return ow.part.file.Sync()
+}
+
+func (ow *outputWriter) Close() error {
+	if ow.w != nil {
+		if err := ow.closePart(); err != nil {
+			return err
+		}
+	}
+	if !ow.o.rotating() {
+		return nil
+	}
+	return ow.writeManifest()
+}
+
+func (ow *outputWriter) full() bool {
+	if !ow.o.rotating() {
+		return false
+	}
+	return (ow.o.maxRows > 0 && ow.rows >= ow.o.maxRows) ||
+		(ow.o.maxBytes > 0 && ow.part.size() >= ow.o.maxBytes)
+}
+
+func (ow *outputWriter) openPart(n int) error {
+	name := ow.o.path
+	if ow.o.rotating() {
+		name = partName(ow.o.path, n+1)
+	}
+	part, err := createPart(name, ow.o.compress)
+	if err != nil {
+		return err
+	}
+	w, err := newDocumentWriter(ow.o.format, part, ow.o.columns, ow.o.header)
+	if err != nil {
+		part.Close()
+		return err
+	}
+	ow.part, ow.w, ow.rows = part, w, 0
+	return nil
+}
+
+func (ow *outputWriter) closePart() error {
+	err := ow.w.Close()
+	ow.parts = append(ow.parts, manifestPart{
+		File:   filepath.Base(ow.part.file.Name()),
+		Rows:   ow.rows,
+		Bytes:  ow.part.size(),
+		SHA256: hex.EncodeToString(ow.part.hash.Sum(nil)),
+	})
+	ow.w = nil
+	return err
+}
+
+func (ow *outputWriter) writeManifest() error {
+	m := manifest{Format: ow.o.format, Compression: ow.o.compress, Parts: ow.parts}
+	for _, p := range ow.parts {
+		m.Rows += p.Rows
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(manifestPath(ow.o.path), append(b, '\n'), 0644)
+}
+
+// splitOutputName splits export.csv.gz into export and .csv.gz.
+func splitOutputName(path string) (string, string) {
+	ext := ""
+	for _, e := range compressionExts {
+		if strings.HasSuffix(path, e) {
+			ext = e
+			path = strings.TrimSuffix(path, e)
+			break
+		}
+	}
+	formatExt := filepath.Ext(path)
+	return strings.TrimSuffix(path, formatExt), formatExt + ext
+}
+
+// partName numbers path: export.csv.gz becomes export-0001.csv.gz.
+func partName(path string, n int) string {
+	base, ext := splitOutputName(path)
+	return fmt.Sprintf("%s-%04d%s", base, n, ext)
+}
+
+func manifestPath(path string) string {
+	base, _ := splitOutputName(path)
+	return base + ".manifest.json"
+}
+
+// replayJSONL writes the documents of a JSON Lines file to w, size at a time.
+func replayJSONL(w documentWriter, name string, size int) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	page := make([]*gabs.Container, 0, size)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			doc, perr := parseJSON(line)
+			if perr != nil {
+				return fmt.Errorf("%s: %v", name, perr)
+			}
+			page = append(page, doc)
+		}
+		if len(page) == size || (err != nil && len(page) > 0) {
+			if werr := w.WritePage(page); werr != nil {
+				return werr
+			}
+			page = page[:0]
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
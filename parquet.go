@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Jeffail/gabs"
+	"github.com/aranajuanm/dsScroller/parquet"
+)
+
+// parquetOutput writes an export to a Parquet file. The schema is the one
+// of the first page, restricted to the columns when there are some, with
+// the types pinned by -types. Documents are written in row groups of
+// rowGroupRows.
+type parquetOutput struct {
+	o    outputOptions
+	file *os.File
+	w    *parquet.Writer
+}
+
+var parquetCodecs = map[string]parquet.Codec{"": parquet.Uncompressed, "gzip": parquet.Gzip, "zstd": parquet.Zstd}
+
+func createParquet(o outputOptions) (*parquetOutput, error) {
+	file, err := os.Create(o.path)
+	if err != nil {
+		return nil, err
+	}
+	return &parquetOutput{o: o, file: file}, nil
+}
+
+func (p *parquetOutput) WritePage(docs []*gabs.Container) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	before := p.Bytes()
+	records := make([]map[string]interface{}, len(docs))
+	for i, doc := range docs {
+		records[i] = parquetRecord(doc, p.o.columns)
+	}
+	if p.w == nil {
+		if err := p.open(records); err != nil {
+			return err
+		}
+	}
+
+	for _, record := range records {
+		if err := p.w.Write(record); err != nil {
+			return err
+		}
+		if p.w.Buffered() >= p.o.rowGroupRows {
+			if err := p.w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	exportMetrics.RecordSimpleMetric(metricOutputRows, float64(len(docs)), "format:parquet")
+	exportMetrics.RecordSimpleMetric(metricOutputBytes, float64(p.Bytes()-before), "format:parquet")
+	return nil
+}
+
+// open starts the file with the schema of the records.
+func (p *parquetOutput) open(records []map[string]interface{}) error {
+	root := &shape{}
+	for _, c := range p.o.columns {
+		root.ensure(c.Path)
+	}
+	for _, record := range records {
+		root.add(record)
+	}
+	paths := make([]string, 0, len(p.o.types))
+	for path := range p.o.types {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		root.ensure(path)
+	}
+
+	fields := make([]*parquet.Field, len(root.names))
+	for i, name := range root.names {
+		fields[i] = root.fields[name].field(name, name, p.o.types)
+	}
+	w, err := parquet.NewWriter(p.file, fields, parquetCodecs[p.o.compress])
+	if err != nil {
+		return err
+	}
+	p.w = w
+	return nil
+}
+
+// Bytes is how much has been written to the file, row groups are only
+// written once full.
+func (p *parquetOutput) Bytes() int64 {
+	if p.w == nil {
+		return 0
+	}
+	return p.w.Size()
+}
+
+func (p *parquetOutput) Offset() int64 {
+	return p.Bytes()
+}
+
+// Sync has nothing to do, parquet exports are not resumed.
+func (p *parquetOutput) Sync() error {
+	return nil
+}
+
+func (p *parquetOutput) Close() error {
+	var err error
+	if p.w == nil {
+		err = p.open(nil)
+	}
+	if err == nil {
+		err = p.w.Close()
+	}
+	if err == nil {
+		p.reportInvalid()
+		err = p.file.Sync()
+	}
+	if cerr := p.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// reportInvalid warns about the values written as nulls because they
+// didn't fit the type their field was given from the first page.
+func (p *parquetOutput) reportInvalid() {
+	invalid := p.w.Invalid()
+	paths := make([]string, 0, len(invalid))
+	for path := range invalid {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		t, _ := p.w.Type(path)
+		exportMetrics.RecordSimpleMetric(metricOutputInvalid, float64(invalid[path]), "format:parquet", "field:"+path)
+		fmt.Fprintf(os.Stderr, "warning: %d values of %s are not %s and were written as null, pin its type with -types %s:<type>\n",
+			invalid[path], path, t, path)
+	}
+}
+
+// parquetRecord is the object of doc, or of the columns of doc.
+func parquetRecord(doc *gabs.Container, columns []column) map[string]interface{} {
+	if len(columns) > 0 {
+		projected := gabs.New()
+		for _, c := range columns {
+			value := doc.Path(c.Path).Data()
+			switch {
+			case value == nil && c.Default == "":
+				continue
+			case value == nil:
+				value = c.Default
+			case c.Format != "":
+				value = formatData(c.Format, value)
+			}
+			projected.SetP(value, c.Path)
+		}
+		doc = projected
+	}
+	record, _ := doc.Data().(map[string]interface{})
+	return record
+}
+
+// parseTypes parses -types: a comma separated list of path:type.
+func parseTypes(spec string) (map[string]parquet.Type, error) {
+	types := make(map[string]parquet.Type)
+	for _, entry := range splitList(spec) {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("-types %s: want path:type", entry)
+		}
+		t, err := parquet.ParseType(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("-types %s: %v", entry, err)
+		}
+		types[parts[0]] = t
+	}
+	return types, nil
+}
+
+type shapeKind int
+
+const (
+	shapeNone shapeKind = iota
+	shapeLeaf
+	shapeObject
+	shapeList
+	shapeMixed
+)
+
+// shape is what the values of a field look like in the sampled documents.
+type shape struct {
+	kind   shapeKind
+	typ    parquet.Type
+	fields map[string]*shape
+	names  []string
+	elem   *shape
+}
+
+func (s *shape) add(v interface{}) {
+	switch v := v.(type) {
+	case nil:
+	case map[string]interface{}:
+		if !s.become(shapeObject) {
+			return
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s.child(k).add(v[k])
+		}
+	case []interface{}:
+		if !s.become(shapeList) {
+			return
+		}
+		for _, item := range v {
+			s.elem.add(item)
+		}
+	default:
+		t := scalarType(v)
+		if s.kind == shapeLeaf {
+			s.typ = widenType(s.typ, t)
+		} else if s.become(shapeLeaf) {
+			s.typ = t
+		}
+	}
+}
+
+// become sets the kind of s when it has none, and tells whether s is of
+// that kind. Values of different kinds make s mixed.
+func (s *shape) become(kind shapeKind) bool {
+	switch s.kind {
+	case kind:
+		return true
+	case shapeNone:
+		s.kind = kind
+		switch kind {
+		case shapeObject:
+			s.fields = make(map[string]*shape)
+		case shapeList:
+			s.elem = &shape{}
+		}
+		return true
+	}
+	s.kind = shapeMixed
+	return false
+}
+
+func (s *shape) child(name string) *shape {
+	c, ok := s.fields[name]
+	if !ok {
+		c = &shape{}
+		s.fields[name] = c
+		s.names = append(s.names, name)
+	}
+	return c
+}
+
+// ensure adds the dotted path to s, so that it is a field even when the
+// sampled documents don't have it. Lists are gone through to their
+// elements.
+func (s *shape) ensure(path string) {
+	for _, name := range strings.Split(path, ".") {
+		for s.kind == shapeList {
+			s = s.elem
+		}
+		if !s.become(shapeObject) {
+			return
+		}
+		s = s.child(name)
+	}
+}
+
+// field is the Parquet field of s. A type pinned on the path of a list is
+// the type of its elements.
+func (s *shape) field(name, path string, types map[string]parquet.Type) *parquet.Field {
+	if s.kind == shapeList {
+		return parquet.List(name, s.elem.field("element", path, types))
+	}
+	if t, ok := types[path]; ok {
+		return parquet.Leaf(name, t)
+	}
+	switch s.kind {
+	case shapeObject:
+		if len(s.names) == 0 {
+			return parquet.Leaf(name, parquet.JSON)
+		}
+		fields := make([]*parquet.Field, len(s.names))
+		for i, child := range s.names {
+			fields[i] = s.fields[child].field(child, path+"."+child, types)
+		}
+		return parquet.Struct(name, fields...)
+	case shapeLeaf:
+		return parquet.Leaf(name, s.typ)
+	case shapeMixed:
+		return parquet.Leaf(name, parquet.JSON)
+	}
+	return parquet.Leaf(name, parquet.String)
+}
+
+func scalarType(v interface{}) parquet.Type {
+	switch v := v.(type) {
+	case bool:
+		return parquet.Boolean
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<63 {
+			return parquet.Int64
+		}
+		return parquet.Double
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return parquet.Int64
+		}
+		return parquet.Double
+	}
+	return parquet.String
+}
+
+// widenType is a type for values of both a and b: double for int64 and
+// double, string for anything else that differs.
+func widenType(a, b parquet.Type) parquet.Type {
+	switch {
+	case a == b:
+		return a
+	case (a == parquet.Int64 && b == parquet.Double) || (a == parquet.Double && b == parquet.Int64):
+		return parquet.Double
+	}
+	return parquet.String
+}
@@ -0,0 +1,191 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"math/bits"
+	"strconv"
+	"time"
+)
+
+// column buffers the values and levels of a leaf until its row group is
+// written.
+type column struct {
+	typ    Type
+	path   []string
+	name   string
+	maxDef int
+	maxRep int
+	// invalid counts the values that could not be converted to typ.
+	invalid int64
+
+	defs   []int
+	reps   []int
+	values bytes.Buffer
+	bools  []bool
+	nulls  int
+}
+
+// add appends v with its levels, or a null when v can't be converted to
+// the type of the column, which is counted.
+func (c *column) add(v interface{}, rep int) {
+	if !c.encode(v) {
+		c.invalid++
+		c.null(rep, c.maxDef-1)
+		return
+	}
+	c.reps = append(c.reps, rep)
+	c.defs = append(c.defs, c.maxDef)
+}
+
+// null appends a missing value, defined up to def.
+func (c *column) null(rep, def int) {
+	c.reps = append(c.reps, rep)
+	c.defs = append(c.defs, def)
+	c.nulls++
+}
+
+// encode appends v to the values with the PLAIN encoding.
+func (c *column) encode(v interface{}) bool {
+	var buf [8]byte
+	switch c.typ {
+	case Boolean:
+		b, ok := v.(bool)
+		if !ok {
+			return false
+		}
+		c.bools = append(c.bools, b)
+	case Int64, Timestamp:
+		n, ok := toInt64(v, c.typ == Timestamp)
+		if !ok {
+			return false
+		}
+		binary.LittleEndian.PutUint64(buf[:], uint64(n))
+		c.values.Write(buf[:])
+	case Double:
+		f, ok := toDouble(v)
+		if !ok {
+			return false
+		}
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(f))
+		c.values.Write(buf[:])
+	default:
+		s, ok := toString(v, c.typ == JSON)
+		if !ok {
+			return false
+		}
+		binary.LittleEndian.PutUint32(buf[:4], uint32(len(s)))
+		c.values.Write(buf[:4])
+		c.values.WriteString(s)
+	}
+	return true
+}
+
+func toInt64(v interface{}, timestamp bool) (int64, bool) {
+	switch v := v.(type) {
+	case float64:
+		if v != math.Trunc(v) || math.Abs(v) > 1<<63 {
+			return 0, false
+		}
+		return int64(v), true
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, true
+		}
+		return toInt64(string(v), timestamp)
+	case string:
+		if timestamp {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return 0, false
+			}
+			return t.UnixNano() / int64(time.Millisecond), true
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func toDouble(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toString(v interface{}, asJSON bool) (string, bool) {
+	if !asJSON {
+		switch v := v.(type) {
+		case string:
+			return v, true
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		case json.Number:
+			return v.String(), true
+		case bool:
+			return strconv.FormatBool(v), true
+		}
+	}
+	b, err := json.Marshal(v)
+	return string(b), err == nil
+}
+
+// page encodes the levels and values of the column as the body of a data
+// page, and resets it.
+func (c *column) page() []byte {
+	var b bytes.Buffer
+	if c.maxRep > 0 {
+		writeLevels(&b, c.reps, c.maxRep)
+	}
+	if c.maxDef > 0 {
+		writeLevels(&b, c.defs, c.maxDef)
+	}
+	if c.typ == Boolean {
+		packed := make([]byte, (len(c.bools)+7)/8)
+		for i, v := range c.bools {
+			if v {
+				packed[i/8] |= 1 << uint(i%8)
+			}
+		}
+		b.Write(packed)
+	} else {
+		b.Write(c.values.Bytes())
+	}
+
+	c.defs, c.reps, c.bools, c.nulls = c.defs[:0], c.reps[:0], c.bools[:0], 0
+	c.values.Reset()
+	return b.Bytes()
+}
+
+// writeLevels writes levels with the RLE encoding, prefixed by its length.
+// Only runs of repeated values are used, never bit packing.
+func writeLevels(b *bytes.Buffer, levels []int, max int) {
+	width := (bits.Len(uint(max)) + 7) / 8
+	var encoded bytes.Buffer
+	var header [binary.MaxVarintLen64]byte
+	for i := 0; i < len(levels); {
+		j := i + 1
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		encoded.Write(header[:binary.PutUvarint(header[:], uint64(j-i)<<1)])
+		for k := 0; k < width; k++ {
+			encoded.WriteByte(byte(levels[i] >> uint(8*k)))
+		}
+		i = j
+	}
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(encoded.Len()))
+	b.Write(length[:])
+	b.Write(encoded.Bytes())
+}
@@ -0,0 +1,264 @@
+package parquet
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Type is the type of the values of a leaf field.
+type Type int
+
+const (
+	String Type = iota
+	Boolean
+	Int64
+	Double
+	// Timestamp holds milliseconds since the epoch, from RFC 3339 strings.
+	Timestamp
+	// JSON holds any value as JSON text.
+	JSON
+)
+
+var typeNames = map[Type]string{
+	String:    "string",
+	Boolean:   "boolean",
+	Int64:     "int64",
+	Double:    "double",
+	Timestamp: "timestamp",
+	JSON:      "json",
+}
+
+func (t Type) String() string {
+	return typeNames[t]
+}
+
+// ParseType parses the name of a Type: string, boolean, int64, double,
+// timestamp or json.
+func ParseType(name string) (Type, error) {
+	for t, n := range typeNames {
+		if n == strings.ToLower(name) {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown type %q, use string, boolean, int64, double, timestamp or json", name)
+}
+
+// Physical and converted types, and repetitions, of the Parquet format.
+const (
+	physicalBoolean   = 0
+	physicalInt64     = 2
+	physicalDouble    = 5
+	physicalByteArray = 6
+
+	convertedUTF8            = 0
+	convertedList            = 3
+	convertedTimestampMillis = 9
+	convertedJSON            = 19
+
+	repetitionOptional = 1
+	repetitionRepeated = 2
+)
+
+func (t Type) physical() int32 {
+	switch t {
+	case Boolean:
+		return physicalBoolean
+	case Int64, Timestamp:
+		return physicalInt64
+	case Double:
+		return physicalDouble
+	}
+	return physicalByteArray
+}
+
+// converted is the converted type of t, -1 when it has none.
+func (t Type) converted() int32 {
+	switch t {
+	case String:
+		return convertedUTF8
+	case Timestamp:
+		return convertedTimestampMillis
+	case JSON:
+		return convertedJSON
+	}
+	return -1
+}
+
+// Field is a field of a schema: a leaf of some Type, a struct of Fields or
+// a list of Elem. Every field is optional, so any of them can be null.
+type Field struct {
+	Name   string
+	Type   Type
+	Fields []*Field
+	Elem   *Field
+}
+
+// Leaf returns a field holding values of typ.
+func Leaf(name string, typ Type) *Field {
+	return &Field{Name: name, Type: typ}
+}
+
+// Struct returns a field holding an object with fields.
+func Struct(name string, fields ...*Field) *Field {
+	return &Field{Name: name, Fields: fields}
+}
+
+// List returns a field holding a list of elem, whose name is not used.
+func List(name string, elem *Field) *Field {
+	return &Field{Name: name, Elem: elem}
+}
+
+func (f *Field) isList() bool {
+	return f.Elem != nil
+}
+
+func (f *Field) isStruct() bool {
+	return f.Elem == nil && f.Fields != nil
+}
+
+// String describes the field, e.g. payer struct<id int64, tags list<string>>.
+func (f *Field) String() string {
+	return f.Name + " " + f.typeString()
+}
+
+func (f *Field) typeString() string {
+	switch {
+	case f.isList():
+		return "list<" + f.Elem.typeString() + ">"
+	case f.isStruct():
+		fields := make([]string, len(f.Fields))
+		for i, child := range f.Fields {
+			fields[i] = child.String()
+		}
+		return "struct<" + strings.Join(fields, ", ") + ">"
+	}
+	return f.Type.String()
+}
+
+// node is a field placed in the schema: its definition and repetition
+// levels, and the column of its values when it is a leaf.
+type node struct {
+	field    *Field
+	def      int
+	rep      int
+	children []*node
+	elem     *node
+	column   *column
+}
+
+// plan builds the nodes of fields under a parent at def and rep, adding
+// the columns of the leaves to columns. path is the Parquet path of the
+// parent and name its path in the records, without the levels of lists.
+func plan(fields []*Field, path []string, name []string, def, rep int, columns *[]*column) ([]*node, error) {
+	nodes := make([]*node, len(fields))
+	names := make(map[string]bool, len(fields))
+	for i, f := range fields {
+		if f.Name == "" {
+			return nil, fmt.Errorf("field without a name in %s", strings.Join(path, "."))
+		}
+		if names[f.Name] {
+			return nil, fmt.Errorf("duplicate field %s", strings.Join(append(path, f.Name), "."))
+		}
+		names[f.Name] = true
+		n, err := planField(f, append(path[:len(path):len(path)], f.Name), append(name[:len(name):len(name)], f.Name), def+1, rep, columns)
+		if err != nil {
+			return nil, err
+		}
+		nodes[i] = n
+	}
+	return nodes, nil
+}
+
+func planField(f *Field, path []string, name []string, def, rep int, columns *[]*column) (*node, error) {
+	n := &node{field: f, def: def, rep: rep}
+	switch {
+	case f.isList():
+		// The three levels of a list: the optional list, the repeated
+		// group of its elements and the optional element.
+		n.rep = rep + 1
+		elem := *f.Elem
+		elem.Name = "element"
+		var err error
+		n.elem, err = planField(&elem, append(path, "list", "element"), name, def+2, rep+1, columns)
+		if err != nil {
+			return nil, err
+		}
+	case f.isStruct():
+		var err error
+		if n.children, err = plan(f.Fields, path, name, def, rep, columns); err != nil {
+			return nil, err
+		}
+	default:
+		n.column = &column{typ: f.Type, path: path, name: strings.Join(name, "."), maxDef: def, maxRep: rep}
+		*columns = append(*columns, n.column)
+	}
+	return n, nil
+}
+
+// leaves calls fn with the column of every leaf under n.
+func (n *node) leaves(fn func(*column)) {
+	switch {
+	case n.column != nil:
+		fn(n.column)
+	case n.elem != nil:
+		n.elem.leaves(fn)
+	default:
+		for _, child := range n.children {
+			child.leaves(fn)
+		}
+	}
+}
+
+// writeSchema writes the schema elements of fields, depth first.
+func writeSchema(t *thriftWriter, fields []*Field) {
+	for _, f := range fields {
+		switch {
+		case f.isList():
+			schemaElement(t, f.Name, -1, repetitionOptional, 1, convertedList)
+			schemaElement(t, "list", -1, repetitionRepeated, 1, -1)
+			elem := *f.Elem
+			elem.Name = "element"
+			writeSchema(t, []*Field{&elem})
+		case f.isStruct():
+			schemaElement(t, f.Name, -1, repetitionOptional, len(f.Fields), -1)
+			writeSchema(t, f.Fields)
+		default:
+			schemaElement(t, f.Name, f.Type.physical(), repetitionOptional, -1, f.Type.converted())
+		}
+	}
+}
+
+// schemaElement writes a SchemaElement, leaving out the fields that are -1.
+func schemaElement(t *thriftWriter, name string, physical int32, repetition int32, children int, converted int32) {
+	t.begin(0)
+	if physical >= 0 {
+		t.i32(1, physical)
+	}
+	if repetition >= 0 {
+		t.i32(3, repetition)
+	}
+	t.string(4, name)
+	if children >= 0 {
+		t.i32(5, int32(children))
+	}
+	if converted >= 0 {
+		t.i32(6, converted)
+	}
+	t.end()
+}
+
+// countElements is how many schema elements fields take.
+func countElements(fields []*Field) int {
+	n := 0
+	for _, f := range fields {
+		switch {
+		case f.isList():
+			n += 2 + countElements([]*Field{f.Elem})
+		case f.isStruct():
+			n += 1 + countElements(f.Fields)
+		default:
+			n++
+		}
+	}
+	return n
+}
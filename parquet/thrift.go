@@ -0,0 +1,102 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Thrift compact protocol types, as used in field and list headers.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the metadata structs of a Parquet file with the
+// Thrift compact protocol. Only what Parquet metadata needs is there.
+type thriftWriter struct {
+	b bytes.Buffer
+	// last is the id of the last field written, for every open struct.
+	last []int16
+}
+
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{last: []int16{0}}
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	last := &t.last[len(t.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.b.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.b.WriteByte(typ)
+		t.varint(int64(id))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) varint(v int64) {
+	var buf [binary.MaxVarintLen64]byte
+	t.b.Write(buf[:binary.PutVarint(buf[:], v)])
+}
+
+func (t *thriftWriter) uvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	t.b.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) string(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.uvarint(uint64(len(s)))
+	t.b.WriteString(s)
+}
+
+// list starts a list field of n elements of typ, to be written next with
+// the element methods.
+func (t *thriftWriter) list(id int16, typ byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.b.WriteByte(byte(n)<<4 | typ)
+		return
+	}
+	t.b.WriteByte(0xf0 | typ)
+	t.uvarint(uint64(n))
+}
+
+func (t *thriftWriter) i32Element(v int32) {
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) stringElement(s string) {
+	t.uvarint(uint64(len(s)))
+	t.b.WriteString(s)
+}
+
+// begin starts a struct field, or a struct element of a list when id is 0.
+func (t *thriftWriter) begin(id int16) {
+	if id != 0 {
+		t.field(id, thriftStruct)
+	}
+	t.last = append(t.last, 0)
+}
+
+func (t *thriftWriter) end() {
+	t.b.WriteByte(0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) bytes() []byte {
+	return t.b.Bytes()
+}
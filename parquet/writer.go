@@ -0,0 +1,318 @@
+// Package parquet writes Parquet files of JSON-like records, decoded into
+// map[string]interface{}, []interface{}, float64, string and bool.
+//
+// It only writes what an export needs: optional fields, structs and lists,
+// PLAIN values with RLE levels, one data page per column and row group, and
+// gzip or zstd compression.
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec is the compression of the pages.
+type Codec int32
+
+const (
+	Uncompressed Codec = 0
+	Gzip         Codec = 2
+	Zstd         Codec = 6
+)
+
+const magic = "PAR1"
+
+// Parquet encodings and page types.
+const (
+	encodingPlain = 0
+	encodingRLE   = 3
+	pageData      = 0
+)
+
+// Writer writes records to a Parquet file with a fixed schema. Records are
+// kept in memory until Flush writes them as a row group.
+type Writer struct {
+	w       io.Writer
+	fields  []*Field
+	codec   Codec
+	nodes   []*node
+	columns []*column
+
+	offset    int64
+	rows      int64
+	groupRows int64
+	groups    []*rowGroup
+	zstd      *zstd.Encoder
+	closed    bool
+}
+
+type rowGroup struct {
+	rows    int64
+	bytes   int64
+	columns []columnChunk
+}
+
+type columnChunk struct {
+	column       *column
+	offset       int64
+	values       int64
+	uncompressed int64
+	compressed   int64
+}
+
+// NewWriter writes the header of a file of fields to w.
+func NewWriter(w io.Writer, fields []*Field, codec Codec) (*Writer, error) {
+	pw := &Writer{w: w, fields: fields, codec: codec}
+	var err error
+	if pw.nodes, err = plan(fields, nil, nil, 0, 0, &pw.columns); err != nil {
+		return nil, err
+	}
+	switch codec {
+	case Uncompressed, Gzip:
+	case Zstd:
+		if pw.zstd, err = zstd.NewWriter(nil); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported codec %d", codec)
+	}
+	if err := pw.write([]byte(magic)); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+func (pw *Writer) write(b []byte) error {
+	n, err := pw.w.Write(b)
+	pw.offset += int64(n)
+	return err
+}
+
+// Write adds a record to the current row group. Fields missing from it, or
+// whose value doesn't fit their type, are null, and the rest are ignored.
+// The values that didn't fit are counted, see Invalid.
+func (pw *Writer) Write(record map[string]interface{}) error {
+	if pw.closed {
+		return errors.New("parquet: write after close")
+	}
+	for _, n := range pw.nodes {
+		pw.shred(n, record[n.field.Name], 0, n.def-1)
+	}
+	pw.groupRows++
+	pw.rows++
+	return nil
+}
+
+// shred adds v to the columns under n, at the repetition level rep. parent
+// is the definition level of what holds v.
+func (pw *Writer) shred(n *node, v interface{}, rep, parent int) {
+	if v == nil {
+		n.leaves(func(c *column) { c.null(rep, parent) })
+		return
+	}
+	switch {
+	case n.column != nil:
+		n.column.add(v, rep)
+	case n.elem != nil:
+		items, ok := v.([]interface{})
+		if !ok {
+			n.leaves(func(c *column) { c.null(rep, parent) })
+			return
+		}
+		if len(items) == 0 {
+			n.leaves(func(c *column) { c.null(rep, n.def) })
+			return
+		}
+		for i, item := range items {
+			if i > 0 {
+				rep = n.rep
+			}
+			pw.shred(n.elem, item, rep, n.def+1)
+		}
+	default:
+		object, ok := v.(map[string]interface{})
+		if !ok {
+			n.leaves(func(c *column) { c.null(rep, parent) })
+			return
+		}
+		for _, child := range n.children {
+			pw.shred(child, object[child.field.Name], rep, n.def)
+		}
+	}
+}
+
+// Invalid is how many values were written as nulls because they could not
+// be converted to the type of their field, by dotted path of the field in
+// the records. Fields without any are left out.
+func (pw *Writer) Invalid() map[string]int64 {
+	invalid := make(map[string]int64)
+	for _, c := range pw.columns {
+		if c.invalid > 0 {
+			invalid[c.name] = c.invalid
+		}
+	}
+	return invalid
+}
+
+// Type is the type of the field at the dotted path of the records.
+func (pw *Writer) Type(path string) (Type, bool) {
+	for _, c := range pw.columns {
+		if c.name == path {
+			return c.typ, true
+		}
+	}
+	return 0, false
+}
+
+// Buffered is how many records are waiting for Flush.
+func (pw *Writer) Buffered() int64 {
+	return pw.groupRows
+}
+
+// Size is how many bytes have been written so far.
+func (pw *Writer) Size() int64 {
+	return pw.offset
+}
+
+// Flush writes the records added since the last Flush as a row group.
+func (pw *Writer) Flush() error {
+	if pw.groupRows == 0 {
+		return nil
+	}
+	group := &rowGroup{rows: pw.groupRows}
+	for _, c := range pw.columns {
+		chunk, err := pw.writeColumn(c)
+		if err != nil {
+			return err
+		}
+		group.bytes += chunk.uncompressed
+		group.columns = append(group.columns, chunk)
+	}
+	pw.groups = append(pw.groups, group)
+	pw.groupRows = 0
+	return nil
+}
+
+// writeColumn writes the buffered values of c as one data page.
+func (pw *Writer) writeColumn(c *column) (columnChunk, error) {
+	chunk := columnChunk{column: c, offset: pw.offset, values: int64(len(c.defs))}
+	values := len(c.defs)
+	body := c.page()
+	compressed, err := pw.compress(body)
+	if err != nil {
+		return chunk, err
+	}
+
+	t := newThriftWriter()
+	t.i32(1, pageData)
+	t.i32(2, int32(len(body)))
+	t.i32(3, int32(len(compressed)))
+	t.begin(5)
+	t.i32(1, int32(values))
+	t.i32(2, encodingPlain)
+	t.i32(3, encodingRLE)
+	t.i32(4, encodingRLE)
+	t.end()
+	t.b.WriteByte(0)
+	header := t.bytes()
+
+	if err := pw.write(header); err != nil {
+		return chunk, err
+	}
+	if err := pw.write(compressed); err != nil {
+		return chunk, err
+	}
+	chunk.uncompressed = int64(len(header) + len(body))
+	chunk.compressed = int64(len(header) + len(compressed))
+	return chunk, nil
+}
+
+func (pw *Writer) compress(body []byte) ([]byte, error) {
+	switch pw.codec {
+	case Gzip:
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		if _, err := zw.Write(body); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	case Zstd:
+		return pw.zstd.EncodeAll(body, nil), nil
+	}
+	return body, nil
+}
+
+// Close flushes the last row group and writes the footer. It doesn't
+// close the underlying writer.
+func (pw *Writer) Close() error {
+	if pw.closed {
+		return nil
+	}
+	if err := pw.Flush(); err != nil {
+		return err
+	}
+	pw.closed = true
+	if pw.zstd != nil {
+		pw.zstd.Close()
+	}
+
+	footer := pw.footer()
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(footer)))
+	for _, b := range [][]byte{footer, length[:], []byte(magic)} {
+		if err := pw.write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// footer encodes the FileMetaData of the file.
+func (pw *Writer) footer() []byte {
+	t := newThriftWriter()
+	t.i32(1, 1)
+	t.list(2, thriftStruct, 1+countElements(pw.fields))
+	schemaElement(t, "schema", -1, -1, len(pw.fields), -1)
+	writeSchema(t, pw.fields)
+	t.i64(3, pw.rows)
+	t.list(4, thriftStruct, len(pw.groups))
+	for _, g := range pw.groups {
+		t.begin(0)
+		t.list(1, thriftStruct, len(g.columns))
+		for _, chunk := range g.columns {
+			t.begin(0)
+			t.i64(2, chunk.offset)
+			t.begin(3)
+			t.i32(1, chunk.column.typ.physical())
+			t.list(2, thriftI32, 2)
+			t.i32Element(encodingPlain)
+			t.i32Element(encodingRLE)
+			t.list(3, thriftBinary, len(chunk.column.path))
+			for _, name := range chunk.column.path {
+				t.stringElement(name)
+			}
+			t.i32(4, int32(pw.codec))
+			t.i64(5, chunk.values)
+			t.i64(6, chunk.uncompressed)
+			t.i64(7, chunk.compressed)
+			t.i64(9, chunk.offset)
+			t.end()
+			t.end()
+		}
+		t.i64(2, g.bytes)
+		t.i64(3, g.rows)
+		t.end()
+	}
+	t.string(6, "dsScroller")
+	t.b.WriteByte(0)
+	return t.bytes()
+}
@@ -0,0 +1,349 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestThriftWriter(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		write func(t *thriftWriter)
+		want  string
+	}{
+		{"short field ids", func(t *thriftWriter) { t.i32(1, 3); t.i32(2, -1) }, "1506" + "1501"},
+		{"long field id", func(t *thriftWriter) { t.i32(1, 0); t.i64(20, 300) }, "1500" + "0628d804"},
+		{"string", func(t *thriftWriter) { t.string(4, "id") }, "48026964"},
+		{"short list", func(t *thriftWriter) { t.list(2, thriftI32, 2); t.i32Element(0); t.i32Element(3) }, "29250006"},
+		{"long list", func(t *thriftWriter) { t.list(1, thriftBinary, 15) }, "19f80f"},
+		{
+			"nested struct",
+			func(t *thriftWriter) { t.i32(3, 1); t.begin(5); t.i32(1, 2); t.end(); t.i32(6, 4) },
+			"3502" + "2c" + "1504" + "00" + "1508",
+		},
+	} {
+		w := newThriftWriter()
+		c.write(w)
+		if got := hex.EncodeToString(w.bytes()); got != c.want {
+			t.Errorf("%s: wrote %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestWriteLevels(t *testing.T) {
+	for _, c := range []struct {
+		levels []int
+		max    int
+		want   string
+	}{
+		{[]int{1, 0, 0, 1}, 1, "06000000" + "0201" + "0400" + "0201"},
+		{[]int{3, 3, 3}, 3, "02000000" + "0603"},
+		{nil, 1, "00000000"},
+		{make([]int, 100), 1, "03000000" + "c80100"},
+		{[]int{300, 2}, 300, "06000000" + "022c01" + "020200"},
+	} {
+		var b bytes.Buffer
+		writeLevels(&b, c.levels, c.max)
+		if got := hex.EncodeToString(b.Bytes()); got != c.want {
+			t.Errorf("writeLevels(%v, %d) = %s, want %s", c.levels, c.max, got, c.want)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	for _, c := range []struct {
+		typ  Type
+		v    interface{}
+		want string
+	}{
+		{Int64, 1.0, "0100000000000000"},
+		{Int64, -2.0, "feffffffffffffff"},
+		{Int64, json.Number("9007199254740993"), "0100000000002000"},
+		{Int64, "42", "2a00000000000000"},
+		{Int64, 1.5, ""},
+		{Int64, "4x", ""},
+		{Int64, true, ""},
+		{Double, 0.5, "000000000000e03f"},
+		{Double, json.Number("0.5"), "000000000000e03f"},
+		{Double, "0.5", "000000000000e03f"},
+		{Double, "half", ""},
+		{Timestamp, "1970-01-01T00:00:01.5Z", "dc05000000000000"},
+		{Timestamp, 1500.0, "dc05000000000000"},
+		{Timestamp, "yesterday", ""},
+		{String, "ab", "020000006162"},
+		{String, 1.5, "03000000312e35"},
+		{String, json.Number("10"), "020000003130"},
+		{String, false, "0500000066616c7365"},
+		{String, []interface{}{"a"}, "05000000" + hex.EncodeToString([]byte(`["a"]`))},
+		{JSON, "a", "03000000" + hex.EncodeToString([]byte(`"a"`))},
+		{JSON, map[string]interface{}{"a": 1.0}, "07000000" + hex.EncodeToString([]byte(`{"a":1}`))},
+	} {
+		col := &column{typ: c.typ}
+		ok := col.encode(c.v)
+		if c.want == "" {
+			if ok {
+				t.Errorf("%s %#v encoded as %x, want it refused", c.typ, c.v, col.values.Bytes())
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("%s %#v refused", c.typ, c.v)
+		} else if got := hex.EncodeToString(col.values.Bytes()); got != c.want {
+			t.Errorf("%s %#v encoded as %s, want %s", c.typ, c.v, got, c.want)
+		}
+	}
+}
+
+// testFields has a leaf, a struct and a list, whose columns are id,
+// user.name and tags.list.element.
+var testFields = []*Field{
+	Leaf("id", Int64),
+	Struct("user", Leaf("name", String)),
+	List("tags", Leaf("", String)),
+}
+
+var testRecords = []map[string]interface{}{
+	{"id": 1.0, "user": map[string]interface{}{"name": "a"}, "tags": []interface{}{"x", "y"}},
+	{"id": "bad", "tags": []interface{}{}},
+	{"id": 3.5, "user": map[string]interface{}{}, "other": true},
+	{"id": json.Number("9007199254740993"), "user": map[string]interface{}{"name": 5.0}, "tags": []interface{}{nil, "z"}},
+}
+
+func TestShred(t *testing.T) {
+	pw, err := NewWriter(ioutil.Discard, testFields, Uncompressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range testRecords {
+		if err := pw.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []struct {
+		name       string
+		defs, reps []int
+	}{
+		{"id", []int{1, 0, 0, 1}, []int{0, 0, 0, 0}},
+		{"user.name", []int{2, 0, 1, 2}, []int{0, 0, 0, 0}},
+		{"tags", []int{3, 3, 1, 0, 2, 3}, []int{0, 1, 0, 0, 0, 1}},
+	}
+	if len(pw.columns) != len(want) {
+		t.Fatalf("%d columns, want %d", len(pw.columns), len(want))
+	}
+	for i, w := range want {
+		c := pw.columns[i]
+		if c.name != w.name || !reflect.DeepEqual(c.defs, w.defs) || !reflect.DeepEqual(c.reps, w.reps) {
+			t.Errorf("column %d is %s with defs %v and reps %v, want %s with %v and %v", i, c.name, c.defs, c.reps, w.name, w.defs, w.reps)
+		}
+	}
+	if got := pw.Invalid(); !reflect.DeepEqual(got, map[string]int64{"id": 2}) {
+		t.Errorf("Invalid() = %v, want id: 2", got)
+	}
+	if typ, ok := pw.Type("tags"); !ok || typ != String {
+		t.Errorf("Type(tags) = %v, %v, want string", typ, ok)
+	}
+	if _, ok := pw.Type("tags.list.element"); ok {
+		t.Error("Type knows the Parquet path of a list element")
+	}
+}
+
+func TestWriterFile(t *testing.T) {
+	for _, codec := range []Codec{Uncompressed, Gzip, Zstd} {
+		var b bytes.Buffer
+		pw, err := NewWriter(&b, testFields, codec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, r := range testRecords {
+			pw.Write(r)
+			// Two row groups of two records.
+			if i == 1 {
+				if err := pw.Flush(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if err := pw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if int64(b.Len()) != pw.Size() {
+			t.Errorf("codec %d: Size() = %d, wrote %d bytes", codec, pw.Size(), b.Len())
+		}
+		checkFile(t, codec, b.Bytes())
+	}
+}
+
+// checkFile reads the footer and the pages of a file of testRecords back.
+func checkFile(t *testing.T, codec Codec, data []byte) {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte(magic)) || !bytes.HasSuffix(data, []byte(magic)) {
+		t.Fatalf("codec %d: file doesn't start and end with %s", codec, magic)
+	}
+	length := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	r := &thriftReader{b: data[len(data)-8-length : len(data)-8]}
+	meta := r.readStruct()
+	if r.err != nil {
+		t.Fatalf("codec %d: footer: %v", codec, r.err)
+	}
+
+	if meta[3] != int64(4) {
+		t.Errorf("codec %d: num_rows = %v, want 4", codec, meta[3])
+	}
+	schema, _ := meta[2].([]interface{})
+	if len(schema) != 1+countElements(testFields) {
+		t.Errorf("codec %d: %d schema elements, want %d", codec, len(schema), 1+countElements(testFields))
+	}
+	var names []string
+	for _, e := range schema {
+		names = append(names, fmt.Sprint(e.(map[int16]interface{})[4]))
+	}
+	if want := []string{"schema", "id", "user", "name", "tags", "list", "element"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("codec %d: schema %v, want %v", codec, names, want)
+	}
+
+	groups, _ := meta[4].([]interface{})
+	if len(groups) != 2 {
+		t.Fatalf("codec %d: %d row groups, want 2", codec, len(groups))
+	}
+	// The second row group holds the last two records, with two tags
+	// levels for the last one.
+	wantPaths := [][]string{{"id"}, {"user", "name"}, {"tags", "list", "element"}}
+	wantValues := []int64{2, 2, 3}
+	for c, chunk := range groups[1].(map[int16]interface{})[1].([]interface{}) {
+		md := chunk.(map[int16]interface{})[3].(map[int16]interface{})
+		var path []string
+		for _, p := range md[3].([]interface{}) {
+			path = append(path, p.(string))
+		}
+		if !reflect.DeepEqual(path, wantPaths[c]) || md[4] != int32(codec) || md[5] != wantValues[c] {
+			t.Errorf("codec %d: column %d is %v of codec %v with %v values, want %v with %d", codec, c, path, md[4], md[5], wantPaths[c], wantValues[c])
+		}
+		if c != 0 {
+			continue
+		}
+
+		page := &thriftReader{b: data[md[9].(int64):]}
+		header := page.readStruct()
+		size := int(header[3].(int32))
+		body := page.b[page.pos : page.pos+size]
+		switch codec {
+		case Gzip:
+			zr, err := gzip.NewReader(bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body, err = ioutil.ReadAll(zr); err != nil {
+				t.Fatal(err)
+			}
+		case Zstd:
+			zr, err := zstd.NewReader(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err = zr.DecodeAll(body, nil)
+			zr.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		// 3.5 is null, only 9007199254740993 is written.
+		want := "04000000" + "0200" + "0201" + "0100000000002000"
+		if got := hex.EncodeToString(body); got != want {
+			t.Errorf("codec %d: page of id is %s, want %s", codec, got, want)
+		}
+	}
+}
+
+// thriftReader decodes Thrift compact structs into maps of field ids, with
+// int32, int64, string, []interface{} and map values.
+type thriftReader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *thriftReader) byte() byte {
+	if r.pos >= len(r.b) {
+		r.err = fmt.Errorf("truncated at %d", r.pos)
+		return 0
+	}
+	r.pos++
+	return r.b[r.pos-1]
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b[r.pos:])
+	if n <= 0 {
+		r.err = fmt.Errorf("bad varint at %d", r.pos)
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) varint() int64 {
+	v := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) readStruct() map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var last int16
+	for r.err == nil {
+		h := r.byte()
+		if h == 0 {
+			break
+		}
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			id = int16(r.varint())
+		}
+		last = id
+		fields[id] = r.value(h & 0x0f)
+	}
+	return fields
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case 1, 2:
+		return typ == 1
+	case thriftI32:
+		return int32(r.varint())
+	case thriftI64:
+		return r.varint()
+	case thriftBinary:
+		n := int(r.uvarint())
+		if r.pos+n > len(r.b) {
+			r.err = fmt.Errorf("truncated string at %d", r.pos)
+			return ""
+		}
+		r.pos += n
+		return string(r.b[r.pos-n : r.pos])
+	case thriftList:
+		h := r.byte()
+		n := int(h >> 4)
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i] = r.value(h & 0x0f)
+		}
+		return items
+	case thriftStruct:
+		return r.readStruct()
+	}
+	r.err = fmt.Errorf("unknown type %d at %d", typ, r.pos)
+	return nil
+}
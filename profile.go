@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jimlawless/cfg"
+)
+
+// configEnv is the environment variable holding the path of the profiles
+// file, ~/.dsscroller.cfg by default.
+const configEnv = "DSSCROLLER_CONFIG"
+
+// defaultProxy is the read proxy the URL of a profile is built on when it
+// only names the application and service.
+const defaultProxy = "https://read-services-proxy.furycloud.io"
+
+// profileFlags are the flags a profile can set. Keys of a profile are
+// either these or one of proxy, application and service, which make -url.
+var profileFlags = []string{"url", "size", "sleep", "rpm", "retries", "timeout", "out-dir", "token-file", "token-command"}
+
+var profileURLKeys = []string{"proxy", "application", "service"}
+
+func addProfileFlag(fs *flag.FlagSet) *string {
+	return fs.String("profile", "", "settings to use from ~/.dsscroller.cfg or $"+configEnv)
+}
+
+// applyProfile sets the flags of fs the command line left alone to the
+// settings of profile. The token settings are only used when no token was
+// given, not even in $DSSCROLLER_TOKEN.
+func applyProfile(fs *flag.FlagSet, profile string) error {
+	if profile == "" {
+		return nil
+	}
+	settings, err := loadProfile(configPath(), profile)
+	if err != nil {
+		return err
+	}
+	if err := profileURL(settings); err != nil {
+		return fmt.Errorf("profile %s: %v", profile, err)
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	tokenGiven := given["token"] || given["token-file"] || given["token-command"] || os.Getenv(tokenEnv) != ""
+	for _, name := range profileFlags {
+		value, ok := settings[name]
+		if !ok || given[name] || fs.Lookup(name) == nil {
+			continue
+		}
+		if tokenGiven && (name == "token-file" || name == "token-command") {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("profile %s: %s: %v", profile, name, err)
+		}
+	}
+	return nil
+}
+
+func configPath() string {
+	if path := os.Getenv(configEnv); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".dsscroller.cfg"
+	}
+	return filepath.Join(home, ".dsscroller.cfg")
+}
+
+// loadProfile reads the settings of profile from path: the keys prefixed
+// with "profile." over the ones without a prefix, shared by all profiles.
+func loadProfile(path string, profile string) (map[string]string, error) {
+	entries := make(map[string]string)
+	if err := cfg.Load(path, entries); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no profiles, %s does not exist", path)
+		}
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	known := make(map[string]bool)
+	for _, key := range append(profileFlags, profileURLKeys...) {
+		known[key] = true
+	}
+	profile = strings.ToLower(profile)
+	profiles := make(map[string]bool)
+	settings := make(map[string]string)
+	for key, value := range entries {
+		name, setting := "", key
+		if i := strings.LastIndex(key, "."); i >= 0 {
+			name, setting = key[:i], key[i+1:]
+			profiles[name] = true
+		}
+		if !known[setting] {
+			return nil, fmt.Errorf("%s: unknown setting %s", path, key)
+		}
+		value = strings.TrimSpace(value)
+		if name == profile || (name == "" && settings[setting] == "") {
+			settings[setting] = value
+		}
+	}
+
+	if !profiles[profile] {
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return nil, fmt.Errorf("%s has no profiles", path)
+		}
+		return nil, fmt.Errorf("no profile %s in %s, there are: %s", profile, path, strings.Join(names, ", "))
+	}
+	return settings, nil
+}
+
+// profileURL sets the url of settings from its proxy, application and
+// service, unless it has one.
+func profileURL(settings map[string]string) error {
+	application, service := settings["application"], settings["service"]
+	if settings["url"] != "" || (application == "" && service == "") {
+		return nil
+	}
+	if application == "" || service == "" {
+		return errors.New("application and service go together, or use url")
+	}
+	proxy := settings["proxy"]
+	if proxy == "" {
+		proxy = defaultProxy
+	}
+	settings["url"] = strings.TrimRight(proxy, "/") + "/applications/" + application + "/ds/services/" + service + "/search"
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testProfiles = `# every profile
+sleep=500
+size=100
+
+prod.application=movements
+prod.service=ds-movements
+prod.size=200
+prod.rpm=300
+prod.token-file=/home/me/.fury-token
+
+dev.url=http://localhost:8080/search
+`
+
+// withConfig points $DSSCROLLER_CONFIG to a file holding content, with
+// no $DSSCROLLER_TOKEN, until the returned func is called.
+func withConfig(t *testing.T, content string) func() {
+	t.Helper()
+	dir := tempDir(t)
+	path := filepath.Join(dir, "dsscroller.cfg")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	restore := func(name string) func() {
+		if value, ok := os.LookupEnv(name); ok {
+			return func() { os.Setenv(name, value) }
+		}
+		return func() { os.Unsetenv(name) }
+	}
+	restoreConfig, restoreToken := restore(configEnv), restore(tokenEnv)
+	os.Setenv(configEnv, path)
+	os.Unsetenv(tokenEnv)
+	return func() {
+		restoreConfig()
+		restoreToken()
+		os.RemoveAll(dir)
+	}
+}
+
+// profileFlagSet parses args with the flags a profile can set, and applies
+// profile to them.
+func profileFlagSet(args []string, profile string) (*flag.FlagSet, error) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("url", "", "")
+	fs.Int("size", 500, "")
+	fs.Int("sleep", 1000, "")
+	fs.Uint64("rpm", 0, "")
+	fs.String("token", "", "")
+	fs.String("token-file", "", "")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	return fs, applyProfile(fs, profile)
+}
+
+func TestApplyProfile(t *testing.T) {
+	defer withConfig(t, testProfiles)()
+
+	for _, c := range []struct {
+		args    []string
+		profile string
+		want    map[string]string
+	}{
+		{
+			profile: "prod",
+			want: map[string]string{
+				"url":        "https://read-services-proxy.furycloud.io/applications/movements/ds/services/ds-movements/search",
+				"size":       "200",
+				"sleep":      "500",
+				"rpm":        "300",
+				"token-file": "/home/me/.fury-token",
+			},
+		},
+		{
+			// The command line wins over the profile.
+			args:    []string{"-size", "50", "-url", "http://other/search", "-token-file", "/tmp/token"},
+			profile: "PROD",
+			want:    map[string]string{"url": "http://other/search", "size": "50", "sleep": "500", "rpm": "300", "token-file": "/tmp/token"},
+		},
+		{
+			// A token given keeps the token settings of the profile out.
+			args:    []string{"-token", "xyzzy"},
+			profile: "prod",
+			want:    map[string]string{"size": "200", "token-file": ""},
+		},
+		{
+			profile: "dev",
+			want:    map[string]string{"url": "http://localhost:8080/search", "size": "100", "sleep": "500", "rpm": "0"},
+		},
+		{
+			args: []string{"-size", "10"},
+			want: map[string]string{"url": "", "size": "10", "sleep": "1000"},
+		},
+	} {
+		fs, err := profileFlagSet(c.args, c.profile)
+		if err != nil {
+			t.Errorf("profile %q with %v: %v", c.profile, c.args, err)
+			continue
+		}
+		for name, want := range c.want {
+			if got := fs.Lookup(name).Value.String(); got != want {
+				t.Errorf("profile %q with %v: -%s is %q, want %q", c.profile, c.args, name, got, want)
+			}
+		}
+	}
+}
+
+func TestApplyProfileErrors(t *testing.T) {
+	for _, c := range []struct {
+		config  string
+		profile string
+		err     string
+	}{
+		{testProfiles, "staging", "no profile staging in"},
+		{testProfiles + "prod.colour=red\n", "prod", "unknown setting prod.colour"},
+		{"timeout=1x\n", "prod", "has no profiles"},
+		{"prod.size=many\n", "prod", "profile prod: size"},
+		{"prod.application=movements\n", "prod", "application and service go together"},
+	} {
+		restore := withConfig(t, c.config)
+		_, err := profileFlagSet(nil, c.profile)
+		restore()
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("profile %s of %q: got %v, want an error with %q", c.profile, c.config, err, c.err)
+		}
+	}
+
+	restore := withConfig(t, testProfiles)
+	os.Setenv(configEnv, filepath.Join(os.TempDir(), "no-such-dsscroller.cfg"))
+	_, err := profileFlagSet(nil, "prod")
+	restore()
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("profile of a missing file: got %v, want an error", err)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// exportProgress reports how the running export is going, nil when nothing
+// should be reported.
+var exportProgress *progress
+
+// progress keeps the counters of an export and reports them on a terminal
+// as a single line redrawn after every page, or as periodic logfmt lines
+// when the output is not a terminal.
+type progress struct {
+	mu       sync.Mutex
+	out      io.Writer
+	terminal bool
+	interval time.Duration
+
+	start    time.Time
+	reported time.Time
+
+	// total is how many documents the export is expected to write, 0 while
+	// unknown. It grows with the total of every scroll that starts.
+	total int64
+	docs  int64
+	pages int64
+	bytes int64
+	// resumed are the documents written before a -resume, left out of the
+	// throughput.
+	resumed int64
+}
+
+func newProgress(out *os.File, interval time.Duration) *progress {
+	terminal := false
+	if fi, err := out.Stat(); err == nil {
+		terminal = fi.Mode()&os.ModeCharDevice != 0
+	}
+	return &progress{out: out, terminal: terminal, interval: interval, start: time.Now()}
+}
+
+// resume starts the counters where a checkpoint left them.
+func (p *progress) resume(docs int64, pages int64, total int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.docs, p.pages, p.total, p.resumed = docs, pages, total, docs
+}
+
+// add counts documents written and expected by scrolls reported apart, as
+// the windows of an export are.
+func (p *progress) add(docs int64, total int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.docs += docs
+	p.total += total
+}
+
+// expect adds the total hit count of a scroll that just started.
+func (p *progress) expect(total int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total += total
+}
+
+// restart forgets the documents a scroll that is being replaced was still
+// expected to return, before the replacement calls expect.
+func (p *progress) restart() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total = p.docs
+}
+
+// expected is the total number of documents known so far.
+func (p *progress) expected() int64 {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.total
+}
+
+// missing is how many documents fewer than expected were written.
+func (p *progress) missing() int64 {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.total <= p.docs {
+		return 0
+	}
+	return p.total - p.docs
+}
+
+// extra is how many documents more than expected were written, 0 while the
+// total is unknown.
+func (p *progress) extra() int64 {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.total == 0 || p.docs <= p.total {
+		return 0
+	}
+	return p.docs - p.total
+}
+
+// documents is how many documents this run has written, leaving out the
+// ones written before a -resume.
+func (p *progress) documents() int64 {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.docs - p.resumed
+}
+
+// page counts a page of docs documents once it has been written.
+func (p *progress) page(docs int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.docs += int64(docs)
+	p.pages++
+
+	now := time.Now()
+	if p.terminal || now.Sub(p.reported) >= p.interval {
+		p.reported = now
+		p.report(false)
+	}
+}
+
+// written sets the bytes written to the output so far.
+func (p *progress) written(bytes int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bytes = bytes
+}
+
+// finish reports the final counters and a summary of what was written to
+// out, given how the export ended.
+func (p *progress) finish(out string, err error) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.report(true)
+
+	outcome := "exported"
+	if errors.Is(err, context.Canceled) {
+		outcome = "interrupted after writing"
+	} else if err != nil {
+		outcome = "failed after writing"
+	}
+	fmt.Fprintf(p.out, "%s %d documents in %d pages to %s (%s) in %v\n",
+		outcome, p.docs, p.pages, out, formatBytes(p.bytes), time.Since(p.start).Round(time.Millisecond))
+}
+
+// report must be called with p.mu held.
+func (p *progress) report(final bool) {
+	elapsed := time.Since(p.start)
+	rate := float64(p.docs-p.resumed) / elapsed.Seconds()
+
+	eta := time.Duration(-1)
+	if p.total > p.docs && rate > 0 {
+		eta = time.Duration(float64(p.total-p.docs) / rate * float64(time.Second)).Round(time.Second)
+	} else if p.total > 0 {
+		eta = 0
+	}
+
+	if !p.terminal {
+		line := fmt.Sprintf("progress docs=%d total=%d pages=%d docs_per_sec=%.1f bytes=%d elapsed=%v",
+			p.docs, p.total, p.pages, rate, p.bytes, elapsed.Round(time.Second))
+		if eta >= 0 {
+			line += fmt.Sprintf(" eta=%v", eta)
+		}
+		if final {
+			line += " final=true"
+		}
+		fmt.Fprintln(p.out, line)
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "\r%d", p.docs)
+	if p.total > 0 {
+		fmt.Fprintf(&b, "/%d docs (%.1f%%)", p.total, 100*float64(p.docs)/float64(p.total))
+	} else {
+		b.WriteString(" docs")
+	}
+	fmt.Fprintf(&b, "  %d pages  %.0f docs/s  %s", p.pages, rate, formatBytes(p.bytes))
+	if final {
+		fmt.Fprintf(&b, "  in %v", elapsed.Round(time.Second))
+	} else if eta >= 0 {
+		fmt.Fprintf(&b, "  ETA %v", eta)
+	}
+	b.WriteString("\033[K")
+	if final {
+		b.WriteString("\n")
+	}
+	fmt.Fprint(p.out, b.String())
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Jeffail/gabs"
+	"github.com/aranajuanm/dsScroller/ds"
+)
+
+// defaultQueryDir is where named queries are looked up when -query is
+// neither "-" nor an existing file.
+const defaultQueryDir = "queries"
+
+var placeholder = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+// queryFlags are the flags shared by every command that needs a query.
+type queryFlags struct {
+	ref    string
+	dir    string
+	params paramsFlag
+}
+
+func addQueryFlags(fs *flag.FlagSet) *queryFlags {
+	q := &queryFlags{params: paramsFlag{}}
+	fs.StringVar(&q.ref, "query", "", `query file, "-" for stdin, or the name of a saved query (required)`)
+	fs.StringVar(&q.dir, "queries", defaultQueryDir, "directory of saved, named queries")
+	fs.Var(q.params, "param", "placeholder value as name=value, replaces {{name}} in the query (repeatable)")
+	return q
+}
+
+func (q *queryFlags) load() (*gabs.Container, error) {
+	return loadQuery(q.ref, q.dir, q.params)
+}
+
+// paramsFlag collects repeated -param name=value flags.
+type paramsFlag map[string]string
+
+func (p paramsFlag) String() string {
+	pairs := make([]string, 0, len(p))
+	for k, v := range p {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (p paramsFlag) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i <= 0 {
+		return fmt.Errorf("expected name=value, got %q", value)
+	}
+	p[value[:i]] = value[i+1:]
+	return nil
+}
+
+// loadQuery reads a DS search body from a file, stdin ("-") or the saved
+// query library in dir, replaces its {{name}} placeholders with params and
+// parses it.
+func loadQuery(ref string, dir string, params map[string]string) (*gabs.Container, error) {
+	if ref == "" {
+		return nil, errors.New("-query is required")
+	}
+
+	body, err := readQuery(ref, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err = expandParams(body, params)
+	if err != nil {
+		return nil, fmt.Errorf("query %s: %v", ref, err)
+	}
+
+	return parseQuery(body)
+}
+
+func readQuery(ref string, dir string) ([]byte, error) {
+	if ref == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+
+	if _, err := os.Stat(ref); err == nil || !isQueryName(ref) {
+		return ioutil.ReadFile(ref)
+	}
+
+	body, err := ioutil.ReadFile(filepath.Join(dir, ref+".json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("query %q is neither a file nor a saved query in %s", ref, dir)
+	}
+	return body, err
+}
+
+// isQueryName tells a bare saved-query name ("unavailable-movements") apart
+// from a path to a file.
+func isQueryName(ref string) bool {
+	return !strings.ContainsRune(ref, filepath.Separator) && !strings.ContainsRune(ref, '/') && filepath.Ext(ref) == ""
+}
+
+// expandParams replaces every {{name}} in body with params[name]. Inside a
+// JSON string the value is escaped, so any text can go there. Outside, it
+// must be a number, true, false or null, so a value can't change the shape
+// of the query.
+func expandParams(body []byte, params map[string]string) ([]byte, error) {
+	var missing []string
+	seen := map[string]bool{}
+	var out bytes.Buffer
+	inString, escaped := false, false
+	last := 0
+	for _, m := range placeholder.FindAllSubmatchIndex(body, -1) {
+		for _, c := range body[last:m[0]] {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\' && inString:
+				escaped = true
+			case c == '"':
+				inString = !inString
+			}
+		}
+		out.Write(body[last:m[0]])
+		last = m[1]
+
+		name := string(body[m[2]:m[3]])
+		value, ok := params[name]
+		switch {
+		case !ok:
+			if !seen[name] {
+				seen[name] = true
+				missing = append(missing, name)
+			}
+			out.Write(body[m[0]:m[1]])
+		case inString:
+			quoted, _ := json.Marshal(value)
+			out.Write(quoted[1 : len(quoted)-1])
+		case !jsonScalar(value):
+			return nil, fmt.Errorf("-param %s=%s: {{%s}} is not in quotes, so it must be a number, true, false or null", name, value, name)
+		default:
+			out.WriteString(value)
+		}
+	}
+	out.Write(body[last:])
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing -param for %s", strings.Join(missing, ", "))
+	}
+	return out.Bytes(), nil
+}
+
+// parseJSON parses queries, and the documents written by an export, with
+// their numbers as json.Number as the scroll returns them, so that ids above
+// 2^53 keep all their digits.
+func parseJSON(b []byte) (*gabs.Container, error) {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	return gabs.ParseJSONDecoder(decoder)
+}
+
+// jsonScalar tells whether value is a JSON number, true, false or null.
+func jsonScalar(value string) bool {
+	var v interface{}
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		return false
+	}
+	switch v.(type) {
+	case float64, bool, nil:
+		return true
+	}
+	return false
+}
+
+func parseQuery(body []byte) (*gabs.Container, error) {
+	request, err := parseJSON(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query JSON: %v", err)
+	}
+	if _, ok := request.Data().(map[string]interface{}); !ok {
+		return nil, errors.New("query body must be a JSON object")
+	}
+	if _, ok := request.Path("query").Data().(map[string]interface{}); !ok {
+		return nil, errors.New(`query body has no "query" object`)
+	}
+	if err := ds.ValidateQuery(request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
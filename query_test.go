@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	const body = `{"query":{"and":[{"eq":{"field":"user.id","value":9007199254740993}},` +
+		`{"date_range":{"field":"date_created","gte":"2019-01-01","lt":"2019-01-03"}}]}}`
+	const digits = `"value":9007199254740993`
+
+	request, err := parseQuery([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(request.String(), digits) {
+		t.Errorf("parseQuery(%s) = %s, want %s", body, request, digits)
+	}
+
+	// The requests of slices and windows are copies of the query, which
+	// keep its digits too.
+	slices, err := sliceQuery(request, "server", "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	windows, _, err := windowQuery(request, "date_created", "day", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range append(slices, windows...) {
+		if !strings.Contains(r.String(), digits) {
+			t.Errorf("got request %s, want %s", r, digits)
+		}
+	}
+
+	for _, body := range []string{
+		`{"query":`,
+		`[]`,
+		`{"size":10}`,
+		`{"query":{"eq":{"field":"","value":1}}}`,
+	} {
+		if _, err := parseQuery([]byte(body)); err == nil {
+			t.Errorf("parseQuery(%s) succeeded, want an error", body)
+		}
+	}
+}
+
+func TestExpandParams(t *testing.T) {
+	for _, c := range []struct {
+		body   string
+		params map[string]string
+		want   string
+		// err is part of the error, when expanding fails.
+		err string
+	}{
+		{
+			body:   `{"eq":{"field":"status","value":"{{status}}"}}`,
+			params: map[string]string{"status": "approved"},
+			want:   `{"eq":{"field":"status","value":"approved"}}`,
+		},
+		{
+			// Values in quotes are escaped, whatever they hold.
+			body:   `{"eq":{"field":"reason","value":"{{reason}}"}}`,
+			params: map[string]string{"reason": `a "quoted" \ path` + "\n" + `", "x": "y`},
+			want:   `{"eq":{"field":"reason","value":"a \"quoted\" \\ path\n\", \"x\": \"y"}}`,
+		},
+		{
+			// A parameter used twice is replaced both times, in and out of
+			// quotes.
+			body:   `{"and":[{"eq":{"field":"user.id","value":{{ user }}}},{"eq":{"field":"ref","value":"user-{{user}}"}}]}`,
+			params: map[string]string{"user": "9007199254740993"},
+			want:   `{"and":[{"eq":{"field":"user.id","value":9007199254740993}},{"eq":{"field":"ref","value":"user-9007199254740993"}}]}`,
+		},
+		{
+			// An escaped quote doesn't end the string.
+			body:   `{"eq":{"field":"note","value":"say \"{{word}}\" {{word}}"}}`,
+			params: map[string]string{"word": "hi}"},
+			want:   `{"eq":{"field":"note","value":"say \"hi}\" hi}"}}`,
+		},
+		{
+			body:   `{"range":{"field":"amount","gte":{{min}},"lt":{{max}}}}`,
+			params: map[string]string{"min": "-1.5e3", "max": "null"},
+			want:   `{"range":{"field":"amount","gte":-1.5e3,"lt":null}}`,
+		},
+		{
+			body:   `{"eq":{"field":"{{a}}","value":{{b}}}}`,
+			params: map[string]string{},
+			err:    "missing -param for a, b",
+		},
+		{
+			body:   `{"eq":{"field":"{{a}}","value":"{{a}}{{c}}"}}`,
+			params: map[string]string{"b": "unused"},
+			err:    "missing -param for a, c",
+		},
+		{
+			body:   `{"eq":{"field":"id","value":{{id}}}}`,
+			params: map[string]string{"id": `1},"or":[{"exists":{"field":"x"}}]`},
+			err:    "{{id}} is not in quotes",
+		},
+		{
+			body:   `{"eq":{"field":"status","value":{{status}}}}`,
+			params: map[string]string{"status": "approved"},
+			err:    "{{status}} is not in quotes",
+		},
+	} {
+		got, err := expandParams([]byte(c.body), c.params)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("expandParams(%s) = %s, %v, want an error with %q", c.body, got, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expandParams(%s): %v", c.body, err)
+			continue
+		}
+		if string(got) != c.want {
+			t.Errorf("expandParams(%s) = %s, want %s", c.body, got, c.want)
+		}
+		if _, err := parseJSON(got); err != nil {
+			t.Errorf("expandParams(%s) = %s, which is not JSON: %v", c.body, got, err)
+		}
+	}
+}
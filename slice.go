@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jeffail/gabs"
+	"github.com/aranajuanm/dsScroller/ds"
+)
+
+// errSliceAborted stops the scroll of a slice because another one failed.
+var errSliceAborted = errors.New("aborted, another slice failed")
+
+// dateLayouts are the date formats a date_range bound may be written in.
+// Those with milliseconds go first: the others parse them too, and the
+// bounds cut are written in the layout that parsed.
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02T15:04:05.000",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04:05.000Z07:00",
+	"2006-01-02T15:04:05Z07:00",
+}
+
+// sliceQuery splits request into n requests matching disjoint sets of
+// documents whose union is what request matches.
+//
+// "date" splits the date_range clause on field in n sub-ranges, "id" does
+// the same with a numeric range clause and "server" relies on the server
+// side "slice": {"id", "max"} parameter.
+func sliceQuery(request *gabs.Container, by string, field string, n int) ([]*gabs.Container, error) {
+	switch by {
+	case "date":
+		return sliceRange(request, "date_range", field, n, splitDates)
+	case "id":
+		if field == "" {
+			field = "id"
+		}
+		return sliceRange(request, "range", field, n, splitNumbers)
+	case "server":
+		slices := make([]*gabs.Container, n)
+		for i := range slices {
+			slices[i] = copyRequest(request)
+			slices[i].Set(map[string]interface{}{"id": i, "max": n}, "slice")
+		}
+		return slices, nil
+	}
+	return nil, fmt.Errorf("unknown -slice-by %q, use date, id or server", by)
+}
+
+// splitter returns the n+1 boundaries splitting [from, to] in n parts. It
+// may return fewer when the range is too small to be split n times.
+type splitter func(from interface{}, to interface{}, n int) ([]interface{}, error)
+
+func sliceRange(request *gabs.Container, op string, field string, n int, split splitter) ([]*gabs.Container, error) {
+	clause := findClause(request.Path("query").Data(), op, field)
+	if clause == nil {
+		if field == "" {
+			return nil, fmt.Errorf("the query has no %s clause to slice", op)
+		}
+		return nil, fmt.Errorf("the query has no %s clause on %q to slice", op, field)
+	}
+
+	from, to := bound(clause, "gte", "gt"), bound(clause, "lte", "lt")
+	if from == nil || to == nil {
+		return nil, fmt.Errorf("the %s clause on %q needs both a lower and an upper bound to be sliced", op, clause["field"])
+	}
+	boundaries, err := split(from, to, n)
+	if err != nil {
+		return nil, fmt.Errorf("cannot slice %s on %q: %v", op, clause["field"], err)
+	}
+
+	original := copyClause(clause)
+	slices := make([]*gabs.Container, 0, len(boundaries)-1)
+	for i := 0; i < len(boundaries)-1; i++ {
+		// Rewrite the clause in place, copy the request and put it back.
+		if i > 0 {
+			delete(clause, "gt")
+			clause["gte"] = boundaries[i]
+		}
+		if i < len(boundaries)-2 {
+			delete(clause, "lte")
+			clause["lt"] = boundaries[i+1]
+		}
+		slices = append(slices, copyRequest(request))
+		restoreClause(clause, original)
+	}
+	return slices, nil
+}
+
+// findClause looks for the first {op: {...}} clause on field (any field if
+// empty) inside a query, walking and/or/not clauses.
+func findClause(query interface{}, op string, field string) map[string]interface{} {
+	switch q := query.(type) {
+	case map[string]interface{}:
+		if clause, ok := q[op].(map[string]interface{}); ok {
+			if f, _ := clause["field"].(string); field == "" || f == field {
+				return clause
+			}
+		}
+		for _, v := range q {
+			if found := findClause(v, op, field); found != nil {
+				return found
+			}
+		}
+	case []interface{}:
+		for _, v := range q {
+			if found := findClause(v, op, field); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+func bound(clause map[string]interface{}, keys ...string) interface{} {
+	for _, k := range keys {
+		if v, ok := clause[k]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+func copyClause(clause map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(clause))
+	for k, v := range clause {
+		c[k] = v
+	}
+	return c
+}
+
+func restoreClause(clause map[string]interface{}, original map[string]interface{}) {
+	for k := range clause {
+		delete(clause, k)
+	}
+	for k, v := range original {
+		clause[k] = v
+	}
+}
+
+func copyRequest(request *gabs.Container) *gabs.Container {
+	c, _ := parseJSON(request.Bytes())
+	return c
+}
+
+func splitDates(from interface{}, to interface{}, n int) ([]interface{}, error) {
+	fromText, ok1 := from.(string)
+	toText, ok2 := to.(string)
+	if !ok1 || !ok2 {
+		return nil, errors.New("bounds are not dates")
+	}
+	start, layout, err := parseDate(fromText)
+	if err != nil {
+		return nil, err
+	}
+	end, _, err := parseDate(toText)
+	if err != nil {
+		return nil, err
+	}
+	if !end.After(start) {
+		return nil, errors.New("the range is empty")
+	}
+
+	// Date only bounds can only be split on whole days.
+	unit := time.Duration(1)
+	if layout == dateLayouts[0] {
+		unit = 24 * time.Hour
+	}
+
+	step := end.Sub(start) / time.Duration(n)
+	boundaries := []interface{}{fromText}
+	last := start
+	for i := 1; i < n; i++ {
+		b := start.Add(step * time.Duration(i)).Truncate(unit)
+		if !b.After(last) || !b.Before(end) {
+			continue
+		}
+		boundaries = append(boundaries, b.Format(layout))
+		last = b
+	}
+	return append(boundaries, toText), nil
+}
+
+func parseDate(text string) (time.Time, string, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t, layout, nil
+		}
+	}
+	return time.Time{}, "", fmt.Errorf("unsupported date %q", text)
+}
+
+// splitNumbers cuts a range of integers, ids usually, in int64 so that
+// bounds above 2^53 keep their digits, and any other range in float64.
+func splitNumbers(from interface{}, to interface{}, n int) ([]interface{}, error) {
+	if start, ok := integerBound(from); ok {
+		if end, ok := integerBound(to); ok {
+			return splitIntegers(from, to, start, end, n)
+		}
+	}
+
+	start, ok1 := floatBound(from)
+	end, ok2 := floatBound(to)
+	if !ok1 || !ok2 {
+		return nil, errors.New("bounds are not numbers")
+	}
+	if end <= start {
+		return nil, errors.New("the range is empty")
+	}
+
+	step := (end - start) / float64(n)
+	boundaries := []interface{}{from}
+	last := start
+	for i := 1; i < n; i++ {
+		b := math.Floor(start + step*float64(i))
+		if b <= last || b >= end {
+			continue
+		}
+		boundaries = append(boundaries, b)
+		last = b
+	}
+	return append(boundaries, to), nil
+}
+
+func splitIntegers(from interface{}, to interface{}, start int64, end int64, n int) ([]interface{}, error) {
+	if end <= start {
+		return nil, errors.New("the range is empty")
+	}
+
+	// The width of the range may not fit an int64.
+	width := new(big.Int).Sub(big.NewInt(end), big.NewInt(start))
+	boundaries := []interface{}{from}
+	last := start
+	for i := 1; i < n; i++ {
+		offset := new(big.Int).Mul(width, big.NewInt(int64(i)))
+		offset.Div(offset, big.NewInt(int64(n)))
+		b := start + offset.Int64()
+		if b <= last || b >= end {
+			continue
+		}
+		boundaries = append(boundaries, json.Number(strconv.FormatInt(b, 10)))
+		last = b
+	}
+	return append(boundaries, to), nil
+}
+
+// integerBound is a bound of a range clause as an int64, when it is a whole
+// number that fits one.
+func integerBound(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil
+	case float64:
+		if n == math.Trunc(n) && n >= math.MinInt64 && n < math.MaxInt64 {
+			return int64(n), true
+		}
+	}
+	return 0, false
+}
+
+// floatBound is a bound of a range clause as a float64.
+func floatBound(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// scrollSlices scrolls every slice with process, at most workers of them at
+// a time (all of them if workers <= 0). The first slice to fail stops the
+// others after their current page and its error is returned.
+func scrollSlices(ctx context.Context, url string, token string, slices []*gabs.Container, workers int, sleep int, callback func(slice int, docs []*gabs.Container) error) error {
+	if workers <= 0 || workers > len(slices) {
+		workers = len(slices)
+	}
+
+	var failed int32
+	errs := make([]error, len(slices))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer redactPanic()
+			defer wg.Done()
+			for i := range next {
+				if atomic.LoadInt32(&failed) != 0 {
+					continue
+				}
+				errs[i] = process(ctx, url, token, slices[i], sleep, func(page *ds.Page) error {
+					if atomic.LoadInt32(&failed) != 0 {
+						return errSliceAborted
+					}
+					return callback(i, page.Documents)
+				})
+				if errs[i] != nil {
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}()
+	}
+	for i := range slices {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil && !errors.Is(err, errSliceAborted) {
+			return fmt.Errorf("slice %d: %w", i, err)
+		}
+	}
+	return nil
+}
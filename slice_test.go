@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSplitDates(t *testing.T) {
+	for _, c := range []struct {
+		from, to interface{}
+		n        int
+		want     []interface{}
+		err      bool
+	}{
+		{
+			from: "2020-01-01", to: "2020-01-05", n: 4,
+			want: []interface{}{"2020-01-01", "2020-01-02", "2020-01-03", "2020-01-04", "2020-01-05"},
+		},
+		{
+			// Date only bounds are split on whole days, so some slices are
+			// merged rather than split inside a day.
+			from: "2020-01-01", to: "2020-01-03", n: 4,
+			want: []interface{}{"2020-01-01", "2020-01-02", "2020-01-03"},
+		},
+		{
+			from: "2020-01-01", to: "2020-01-02", n: 3,
+			want: []interface{}{"2020-01-01", "2020-01-02"},
+		},
+		{
+			from: "2020-01-01T00:00:00", to: "2020-01-01T06:00:00", n: 3,
+			want: []interface{}{"2020-01-01T00:00:00", "2020-01-01T02:00:00", "2020-01-01T04:00:00", "2020-01-01T06:00:00"},
+		},
+		{
+			from: "2020-01-01T00:00:00.000-04:00", to: "2020-01-02T00:00:00.000-04:00", n: 2,
+			want: []interface{}{"2020-01-01T00:00:00.000-04:00", "2020-01-01T12:00:00.000-04:00", "2020-01-02T00:00:00.000-04:00"},
+		},
+		{from: "2020-01-01", to: "2020-01-05", n: 1, want: []interface{}{"2020-01-01", "2020-01-05"}},
+		{from: "2020-01-05", to: "2020-01-01", n: 2, err: true},
+		{from: "2020-01-01", to: "2020-01-01", n: 2, err: true},
+		{from: "yesterday", to: "2020-01-01", n: 2, err: true},
+		{from: 1577836800000.0, to: 1578441600000.0, n: 2, err: true},
+	} {
+		got, err := splitDates(c.from, c.to, c.n)
+		if c.err {
+			if err == nil {
+				t.Errorf("splitDates(%v, %v, %d) = %v, want an error", c.from, c.to, c.n, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("splitDates(%v, %v, %d): %v", c.from, c.to, c.n, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("splitDates(%v, %v, %d) = %v, want %v", c.from, c.to, c.n, got, c.want)
+		}
+	}
+}
+
+func TestSplitNumbers(t *testing.T) {
+	for _, c := range []struct {
+		from, to interface{}
+		n        int
+		want     []interface{}
+		err      bool
+	}{
+		{
+			from: json.Number("0"), to: json.Number("100"), n: 4,
+			want: []interface{}{json.Number("0"), json.Number("25"), json.Number("50"), json.Number("75"), json.Number("100")},
+		},
+		{
+			// Ids above 2^53 are cut with all their digits.
+			from: json.Number("9007199254740993"), to: json.Number("9007199254741000"), n: 2,
+			want: []interface{}{json.Number("9007199254740993"), json.Number("9007199254740996"), json.Number("9007199254741000")},
+		},
+		{
+			// The width of the range doesn't fit an int64.
+			from: json.Number("-9223372036854775808"), to: json.Number("9223372036854775807"), n: 2,
+			want: []interface{}{json.Number("-9223372036854775808"), json.Number("-1"), json.Number("9223372036854775807")},
+		},
+		{
+			// Too small to be cut 4 times.
+			from: json.Number("1"), to: json.Number("3"), n: 4,
+			want: []interface{}{json.Number("1"), json.Number("2"), json.Number("3")},
+		},
+		{
+			from: 0.0, to: 10.0, n: 2,
+			want: []interface{}{0.0, json.Number("5"), 10.0},
+		},
+		{
+			from: json.Number("0.5"), to: json.Number("10.5"), n: 2,
+			want: []interface{}{json.Number("0.5"), 5.0, json.Number("10.5")},
+		},
+		{from: json.Number("10"), to: json.Number("10"), n: 2, err: true},
+		{from: json.Number("10"), to: json.Number("1"), n: 2, err: true},
+		{from: "2020-01-01", to: json.Number("10"), n: 2, err: true},
+	} {
+		got, err := splitNumbers(c.from, c.to, c.n)
+		if c.err {
+			if err == nil {
+				t.Errorf("splitNumbers(%v, %v, %d) = %v, want an error", c.from, c.to, c.n, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("splitNumbers(%v, %v, %d): %v", c.from, c.to, c.n, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("splitNumbers(%v, %v, %d) = %v, want %v", c.from, c.to, c.n, got, c.want)
+		}
+	}
+}
+
+func TestSliceQuery(t *testing.T) {
+	request, err := parseQuery([]byte(`{"query":{"and":[{"range":{"field":"id","gte":9007199254740993,"lt":9007199254741000}}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices, err := sliceQuery(request, "id", "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range slices {
+		got = append(got, s.Path("query").String())
+	}
+	want := []string{
+		`{"and":[{"range":{"field":"id","gte":9007199254740993,"lt":9007199254740996}}]}`,
+		`{"and":[{"range":{"field":"id","gte":9007199254740996,"lt":9007199254741000}}]}`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sliceQuery = %v, want %v", got, want)
+	}
+
+	for _, body := range []string{
+		`{"query":{"eq":{"field":"status","value":"ok"}}}`,
+		`{"query":{"range":{"field":"id","gte":1}}}`,
+		`{"query":{"range":{"field":"amount","gte":1,"lt":5}}}`,
+	} {
+		request, err := parseQuery([]byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if slices, err := sliceQuery(request, "id", "", 2); err == nil {
+			t.Errorf("sliceQuery(%s) = %v, want an error", body, slices)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Jeffail/gabs"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteOutput writes an export to a table of a SQLite database, one row
+// per document and one transaction per page. Columns are named after their
+// path, e.g. "payer.id", and typed from the first page. Without columns
+// they are the fields found in the documents, nested ones included: those
+// of the first page create the table, and the ones found later are added.
+type sqliteOutput struct {
+	o       outputOptions
+	db      *sql.DB
+	columns []column
+	created bool
+	rows    int64
+}
+
+// createSQLite creates the database of o.path. A file there is replaced
+// with o.overwrite, and an error otherwise.
+func createSQLite(o outputOptions) (*sqliteOutput, error) {
+	if _, err := os.Stat(o.path); err == nil && !o.overwrite {
+		return nil, fmt.Errorf("%s exists, pass -overwrite to replace it", o.path)
+	}
+	for _, name := range []string{o.path, o.path + "-journal"} {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return openSQLite(o)
+}
+
+// resumeSQLite reopens the database of an interrupted export, dropping the
+// rows written after the first rows ones.
+func resumeSQLite(o outputOptions, rows int64) (*sqliteOutput, error) {
+	if _, err := os.Stat(o.path); err != nil {
+		return nil, err
+	}
+	s, err := openSQLite(o)
+	if err != nil {
+		return nil, err
+	}
+	names, err := s.tableColumns()
+	if err != nil {
+		s.db.Close()
+		return nil, err
+	}
+	if len(names) == 0 {
+		return s, nil
+	}
+	if s.columns == nil {
+		for _, name := range names {
+			s.columns = append(s.columns, column{Path: name})
+		}
+	}
+	s.created = true
+	if _, err := s.db.Exec("DELETE FROM "+quoteSQL(o.table)+" WHERE rowid > ?", rows); err != nil {
+		s.db.Close()
+		return nil, err
+	}
+	s.rows = rows
+	return s, nil
+}
+
+func openSQLite(o outputOptions) (*sqliteOutput, error) {
+	db, err := sql.Open("sqlite3", o.path)
+	if err != nil {
+		return nil, err
+	}
+	// One connection, so that every statement sees the same transaction.
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %v", o.path, err)
+	}
+	return &sqliteOutput{o: o, db: db, columns: o.columns}, nil
+}
+
+// tableColumns lists the columns of the table, none when it doesn't exist.
+func (s *sqliteOutput) tableColumns() ([]string, error) {
+	rows, err := s.db.Query("SELECT name FROM pragma_table_info(?) ORDER BY cid", s.o.table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func (s *sqliteOutput) WritePage(docs []*gabs.Container) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	before := s.Bytes()
+	if !s.created {
+		if s.columns == nil {
+			s.columns = documentColumns(docs)
+		}
+		if err := s.createTable(docs); err != nil {
+			return err
+		}
+	} else if s.o.columns == nil {
+		if err := s.addColumns(docs); err != nil {
+			return err
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(s.columns)), ",")
+	insert, err := tx.Prepare("INSERT INTO " + quoteSQL(s.o.table) + " VALUES (" + placeholders + ")")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	values := make([]interface{}, len(s.columns))
+	for _, doc := range docs {
+		for i, c := range s.columns {
+			values[i] = sqliteValue(c, doc)
+		}
+		if _, err := insert.Exec(values...); err != nil {
+			insert.Close()
+			tx.Rollback()
+			return err
+		}
+	}
+	insert.Close()
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.rows += int64(len(docs))
+	exportMetrics.RecordSimpleMetric(metricOutputRows, float64(len(docs)), "format:sqlite")
+	exportMetrics.RecordSimpleMetric(metricOutputBytes, float64(s.Bytes()-before), "format:sqlite")
+	return nil
+}
+
+// createTable creates the table with the types of the values in docs.
+func (s *sqliteOutput) createTable(docs []*gabs.Container) error {
+	columns := make(map[string]bool, len(s.columns))
+	definitions := make([]string, len(s.columns))
+	for i, c := range s.columns {
+		columns[c.Path] = true
+		definitions[i] = quoteSQL(c.Path) + " " + sqliteType(c, docs)
+	}
+	for _, index := range s.o.indexes {
+		if !columns[index] {
+			return fmt.Errorf("-index %s is not a column of %s", index, s.o.table)
+		}
+	}
+
+	statement := "CREATE TABLE " + quoteSQL(s.o.table) + " (" + strings.Join(definitions, ", ") + ")"
+	if _, err := s.db.Exec(statement); err != nil {
+		return fmt.Errorf("creating %s: %v", s.o.table, err)
+	}
+	s.created = true
+	return nil
+}
+
+// addColumns adds to the table the fields of docs it has no column for,
+// typed from their values in docs.
+func (s *sqliteOutput) addColumns(docs []*gabs.Container) error {
+	columns := make(map[string]bool, len(s.columns))
+	for _, c := range s.columns {
+		columns[c.Path] = true
+	}
+	for _, c := range documentColumns(docs) {
+		if columns[c.Path] {
+			continue
+		}
+		statement := "ALTER TABLE " + quoteSQL(s.o.table) + " ADD COLUMN " + quoteSQL(c.Path) + " " + sqliteType(c, docs)
+		if _, err := s.db.Exec(statement); err != nil {
+			return fmt.Errorf("adding %s to %s: %v", c.Path, s.o.table, err)
+		}
+		s.columns = append(s.columns, c)
+	}
+	return nil
+}
+
+// Bytes is the size of the database file.
+func (s *sqliteOutput) Bytes() int64 {
+	fi, err := os.Stat(s.o.path)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// Offset is the number of rows written, which is what resumeSQLite needs.
+func (s *sqliteOutput) Offset() int64 {
+	return s.rows
+}
+
+// Sync has nothing to do, every page is committed and so synced.
+func (s *sqliteOutput) Sync() error {
+	return nil
+}
+
+// Close creates the indexes once all the rows are in, which is faster than
+// keeping them up to date on every insert.
+func (s *sqliteOutput) Close() error {
+	err := s.createIndexes()
+	if cerr := s.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *sqliteOutput) createIndexes() error {
+	if !s.created {
+		if s.columns == nil {
+			if len(s.o.indexes) > 0 {
+				fmt.Fprintf(os.Stderr, "warning: no documents were exported, so %s has no table to -index %s\n", s.o.path, strings.Join(s.o.indexes, ","))
+			}
+			return nil
+		}
+		if err := s.createTable(nil); err != nil {
+			return err
+		}
+	}
+	for _, index := range s.o.indexes {
+		name := quoteSQL(s.o.table + "_" + index)
+		statement := "CREATE INDEX IF NOT EXISTS " + name + " ON " + quoteSQL(s.o.table) + " (" + quoteSQL(index) + ")"
+		if _, err := s.db.Exec(statement); err != nil {
+			return fmt.Errorf("indexing %s: %v", index, err)
+		}
+	}
+	return nil
+}
+
+// documentColumns are the leaf fields of docs, in the order they are first
+// found and sorted within every object. Arrays are leaves.
+func documentColumns(docs []*gabs.Container) []column {
+	seen := make(map[string]bool)
+	var columns []column
+	var walk func(prefix string, data interface{})
+	walk = func(prefix string, data interface{}) {
+		object, ok := data.(map[string]interface{})
+		if !ok || len(object) == 0 {
+			if prefix != "" && !seen[prefix] {
+				seen[prefix] = true
+				columns = append(columns, column{Path: prefix})
+			}
+			return
+		}
+		keys := make([]string, 0, len(object))
+		for k := range object {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			path := k
+			if prefix != "" {
+				path = prefix + "." + k
+			}
+			walk(path, object[k])
+		}
+	}
+	for _, doc := range docs {
+		walk("", doc.Data())
+	}
+	return columns
+}
+
+// sqliteType is the type of the column for the values it has in docs: the
+// widest of INTEGER, REAL and TEXT. Formatted columns are TEXT.
+func sqliteType(c column, docs []*gabs.Container) string {
+	if c.Format != "" {
+		return "TEXT"
+	}
+	kind := ""
+	for _, doc := range docs {
+		switch v := doc.Path(c.Path).Data().(type) {
+		case nil:
+		case bool:
+			if kind == "" {
+				kind = "INTEGER"
+			}
+		case float64:
+			if v != math.Trunc(v) {
+				kind = "REAL"
+			} else if kind == "" {
+				kind = "INTEGER"
+			}
+		case json.Number:
+			if _, err := v.Int64(); err != nil {
+				kind = "REAL"
+			} else if kind == "" {
+				kind = "INTEGER"
+			}
+		default:
+			return "TEXT"
+		}
+	}
+	if kind == "" {
+		return "TEXT"
+	}
+	return kind
+}
+
+// sqliteValue is the value of the column for doc: NULL when missing,
+// objects and arrays as JSON.
+func sqliteValue(c column, doc *gabs.Container) interface{} {
+	data := doc.Path(c.Path).Data()
+	if data == nil {
+		if c.Default == "" {
+			return nil
+		}
+		return c.Default
+	}
+	if c.Format != "" {
+		return formatData(c.Format, data)
+	}
+	switch v := data.(type) {
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(data)
+		return string(b)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	}
+	return data
+}
+
+// quoteSQL quotes an identifier, so that paths like payer.id can be names.
+func quoteSQL(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
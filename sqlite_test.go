@@ -0,0 +1,141 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aranajuanm/dsScroller/ds/dstest"
+)
+
+// queryRows returns the rows of query on the database at path, every value
+// as a string and NULL as "<nil>".
+func queryRows(t *testing.T, path string, query string) [][]string {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result [][]string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			t.Fatal(err)
+		}
+		row := make([]string, len(columns))
+		for i, v := range values {
+			row[i] = "<nil>"
+			if v.Valid {
+				row[i] = v.String
+			}
+		}
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestExportSQLite(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out.sqlite")
+
+	// Ids above 2^53 keep their digits, and refunds only show up on the
+	// second page.
+	docs := dstest.Documents(25)
+	for i, doc := range docs {
+		doc["id"] = json.Number(strconv.FormatInt(9007199254740993+int64(i), 10))
+		if i >= 15 {
+			doc["refund"] = map[string]interface{}{"amount": float64(i) + 0.5, "reason": "late"}
+		}
+	}
+	srv := dstest.NewServer(docs)
+	defer srv.Close()
+
+	if _, err := testExport(t, srv, dir, "-format", "sqlite", "-out", "out.sqlite", "-table", "movements", "-index", "status,user.id"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := queryRows(t, out, `SELECT name, type FROM pragma_table_info('movements') ORDER BY cid`)
+	want := [][]string{
+		{"amount", "REAL"},
+		{"date_created", "TEXT"},
+		{"id", "INTEGER"},
+		{"status", "TEXT"},
+		{"user.id", "INTEGER"},
+		{"user.name", "TEXT"},
+		{"refund.amount", "REAL"},
+		{"refund.reason", "TEXT"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("columns %v, want %v", got, want)
+	}
+
+	got = queryRows(t, out, `SELECT count(*), min(id), max(id), count("refund.amount"), sum("refund.amount") FROM movements`)
+	if want := [][]string{{"25", "9007199254740993", "9007199254741017", "10", "200"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	got = queryRows(t, out, `SELECT id, status, "user.id", "refund.reason" FROM movements WHERE rowid IN (1, 16)`)
+	want = [][]string{
+		{"9007199254740993", "available", "0", "<nil>"},
+		{"9007199254741008", "available", "1", "late"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got rows %v, want %v", got, want)
+	}
+	got = queryRows(t, out, `SELECT name FROM sqlite_master WHERE type = 'index' ORDER BY name`)
+	if want := [][]string{{"movements_status"}, {"movements_user.id"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("indexes %v, want %v", got, want)
+	}
+
+	// The database is only replaced with -overwrite.
+	_, err := testExport(t, srv, dir, "-format", "sqlite", "-out", "out.sqlite", "-table", "movements")
+	if err == nil || !strings.Contains(err.Error(), "-overwrite") {
+		t.Errorf("export over a database = %v, want an error asking for -overwrite", err)
+	}
+	if _, err := testExport(t, srv, dir, "-format", "sqlite", "-out", "out.sqlite", "-table", "movements", "-overwrite"); err != nil {
+		t.Fatal(err)
+	}
+	if got := queryRows(t, out, `SELECT count(*) FROM movements`); got[0][0] != "25" {
+		t.Errorf("overwritten database has %s rows, want 25", got[0][0])
+	}
+}
+
+func TestExportSQLiteEmpty(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	srv := dstest.NewServer(nil)
+	defer srv.Close()
+
+	stderr, err := capture(t, &os.Stderr, func() error {
+		_, err := testExport(t, srv, dir, "-format", "sqlite", "-out", "out.sqlite", "-index", "id")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "no table to -index id"; !strings.Contains(stderr, want) {
+		t.Errorf("the export printed\n%s\nwant a warning with %q", stderr, want)
+	}
+}
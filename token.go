@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// tokenEnv is the environment variable the fury token is read from when no
+// token flag is given.
+const tokenEnv = "DSSCROLLER_TOKEN"
+
+const redacted = "[REDACTED]"
+
+// tokenFlags are the ways a command can be given the fury token. Only one
+// of them may be used at a time.
+type tokenFlags struct {
+	token   string
+	file    string
+	command string
+}
+
+func addTokenFlags(fs *flag.FlagSet) *tokenFlags {
+	t := &tokenFlags{}
+	fs.StringVar(&t.token, "token", "", "fury token sent as x-auth-token, visible to other users in ps (prefer -token-file or $"+tokenEnv+")")
+	fs.StringVar(&t.file, "token-file", "", "file holding the fury token, readable by its owner only")
+	fs.StringVar(&t.command, "token-command", "", "command printing the fury token on stdout, run with sh -c")
+	return t
+}
+
+// resolve returns the token from the flag given or, without one, from
+// $DSSCROLLER_TOKEN. It is empty when there is none anywhere. The token is
+// registered to be redacted before being returned.
+func (t *tokenFlags) resolve() (string, error) {
+	given := 0
+	for _, v := range []string{t.token, t.file, t.command} {
+		if v != "" {
+			given++
+		}
+	}
+	if given > 1 {
+		return "", errors.New("use only one of -token, -token-file and -token-command")
+	}
+
+	var token string
+	var err error
+	switch {
+	case t.token != "":
+		token = t.token
+	case t.file != "":
+		token, err = readTokenFile(t.file)
+	case t.command != "":
+		token, err = runTokenCommand(t.command)
+	default:
+		token = os.Getenv(tokenEnv)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	token = strings.TrimSpace(token)
+	addSecret(token)
+	return token, nil
+}
+
+// readTokenFile reads a token file, refusing it when other users can read
+// or write it.
+func readTokenFile(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("token file %s can be accessed by other users (mode %04o), run chmod 600 on it", path, fi.Mode().Perm())
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// runTokenCommand runs a credential helper and returns what it prints. Its
+// stderr goes to ours, so it can prompt or explain why it failed.
+func runTokenCommand(command string) (string, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("-token-command failed: %v", err)
+	}
+	token := strings.TrimSpace(string(out))
+	if token == "" {
+		return "", errors.New("-token-command printed no token")
+	}
+	return token, nil
+}
+
+var (
+	secretsMtx sync.RWMutex
+	secrets    []string
+)
+
+// addSecret registers a value that redact must hide.
+func addSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	secretsMtx.Lock()
+	defer secretsMtx.Unlock()
+	secrets = append(secrets, secret)
+}
+
+// minSecret is the length under which a secret is only redacted where it
+// stands as a whole word, so that a short token doesn't mangle every
+// message holding its letters.
+const minSecret = 8
+
+// redact replaces every registered secret in s. Anything printed that may
+// hold a token (errors, response bodies, debug dumps, panics) goes through
+// it.
+func redact(s string) string {
+	secretsMtx.RLock()
+	defer secretsMtx.RUnlock()
+	for _, secret := range secrets {
+		if len(secret) >= minSecret {
+			s = strings.Replace(s, secret, redacted, -1)
+		} else {
+			s = redactWord(s, secret)
+		}
+	}
+	return s
+}
+
+// redactWord replaces secret in s where no letter, digit, '-' or '_' is
+// next to it.
+func redactWord(s, secret string) string {
+	var b strings.Builder
+	// from is where the search goes on, past the matches already handled,
+	// which still tell whether the next one is inside a word.
+	from := 0
+	for {
+		i := strings.Index(s[from:], secret)
+		if i < 0 {
+			b.WriteString(s[from:])
+			return b.String()
+		}
+		i += from
+		end := i + len(secret)
+		if (i == 0 || !wordByte(s[i-1])) && (end == len(s) || !wordByte(s[end])) {
+			b.WriteString(s[from:i])
+			b.WriteString(redacted)
+		} else {
+			b.WriteString(s[from:end])
+		}
+		from = end
+	}
+}
+
+func wordByte(c byte) bool {
+	return c == '-' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
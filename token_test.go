@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestRedactWord(t *testing.T) {
+	for _, c := range []struct {
+		s, secret, want string
+	}{
+		// A short secret inside a longer word is left alone.
+		{"xyzzy", "zz", "xyzzy"},
+		{"token_abc123 is ok", "abc", "token_abc123 is ok"},
+		{"abc-def", "abc", "abc-def"},
+		{"ababab", "ab", "ababab"},
+		{"xabab", "ab", "xabab"},
+
+		// Standing alone it is redacted: at the start or end of the string,
+		// next to punctuation and every time it appears.
+		{"abc", "abc", redacted},
+		{"abc is the token", "abc", redacted + " is the token"},
+		{"the token is abc", "abc", "the token is " + redacted},
+		{`{"token":"abc"}`, "abc", `{"token":"` + redacted + `"}`},
+		{"x-auth-token: abc.", "abc", "x-auth-token: " + redacted + "."},
+		{"(abc),abc;abc", "abc", "(" + redacted + ")," + redacted + ";" + redacted},
+		{"abcabc abc xabc abc", "abc", "abcabc " + redacted + " xabc " + redacted},
+		{"", "abc", ""},
+	} {
+		if got := redactWord(c.s, c.secret); got != c.want {
+			t.Errorf("redactWord(%q, %q) = %q, want %q", c.s, c.secret, got, c.want)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	secretsMtx.Lock()
+	saved := secrets
+	secrets = nil
+	secretsMtx.Unlock()
+	defer func() {
+		secretsMtx.Lock()
+		secrets = saved
+		secretsMtx.Unlock()
+	}()
+
+	addSecret("tok")
+	addSecret("0123456789abcdef")
+	addSecret("")
+	for _, c := range []struct {
+		s, want string
+	}{
+		// Long secrets are redacted anywhere, short ones only as words.
+		{"token=tok", "token=" + redacted},
+		{"stock tokens", "stock tokens"},
+		{"Bearer 0123456789abcdef0123456789abcdef", "Bearer " + redacted + redacted},
+		{"x0123456789abcdefx tok", "x" + redacted + "x " + redacted},
+	} {
+		if got := redact(c.s); got != c.want {
+			t.Errorf("redact(%q) = %q, want %q", c.s, got, c.want)
+		}
+	}
+}
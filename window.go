@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/Jeffail/gabs"
+)
+
+// windowUnits are the sizes of the windows of -window.
+var windowUnits = map[string]bool{"day": true, "week": true, "month": true}
+
+// Status of a window of a windowed export.
+const (
+	windowPending = "pending"
+	windowDone    = "done"
+	windowFailed  = "failed"
+)
+
+// windowState records how far every window of a windowed export got, so
+// that rerunning it with -resume only scrolls the windows not done yet.
+type windowState struct {
+	path string
+
+	// Query is the search body the windows are cut from.
+	Query    json.RawMessage `json:"query"`
+	Field    string          `json:"field"`
+	Window   string          `json:"window"`
+	TimeZone string          `json:"time_zone,omitempty"`
+	Windows  []*windowRecord `json:"windows"`
+}
+
+type windowRecord struct {
+	Label      string     `json:"label"`
+	From       string     `json:"from"`
+	To         string     `json:"to"`
+	File       string     `json:"file"`
+	Status     string     `json:"status"`
+	Documents  int64      `json:"documents,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// windowStatePath is where the state of the windowed export to out is.
+func windowStatePath(out string) string {
+	base, _ := splitOutputName(out)
+	return base + ".windows.json"
+}
+
+// windowName is the output file of the window labeled label.
+func windowName(out string, label string) string {
+	base, ext := splitOutputName(out)
+	return base + "-" + label + ext
+}
+
+func (s *windowState) save() error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, append(b, '\n'))
+}
+
+// planWindows cuts the export in windows, or picks up the ones of the last
+// run with -resume. It returns the first request of every window.
+func planWindows(o exportOptions, query *queryFlags) (*windowState, []*gabs.Container, error) {
+	path := windowStatePath(o.out)
+	var state *windowState
+	if o.resume {
+		b, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			return nil, nil, fmt.Errorf("nothing to resume, %s does not exist", path)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		state = &windowState{path: path}
+		if err := json.Unmarshal(b, state); err != nil {
+			return nil, nil, fmt.Errorf("corrupt %s: %v", path, err)
+		}
+	} else {
+		request, err := loadScrollRequest(query, o.size)
+		if err != nil {
+			return nil, nil, err
+		}
+		state = &windowState{
+			path:     path,
+			Query:    json.RawMessage(request.Bytes()),
+			Field:    o.windowField,
+			Window:   o.window,
+			TimeZone: o.windowTZ,
+		}
+	}
+
+	request, err := parseQuery(state.Query)
+	if err != nil {
+		return nil, nil, err
+	}
+	requests, bounds, err := windowQuery(request, state.Field, state.Window, state.TimeZone)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if o.resume {
+		if len(state.Windows) != len(requests) {
+			return nil, nil, fmt.Errorf("corrupt %s: %d windows recorded, the query has %d", path, len(state.Windows), len(requests))
+		}
+		return state, requests, nil
+	}
+	for i := range requests {
+		label := windowLabel(bounds[i], state.Window)
+		state.Windows = append(state.Windows, &windowRecord{
+			Label:  label,
+			From:   bounds[i].text,
+			To:     bounds[i+1].text,
+			File:   windowName(o.out, label),
+			Status: windowPending,
+		})
+	}
+	return state, requests, nil
+}
+
+// windowBound is a boundary of the windows, as written in the query.
+type windowBound struct {
+	text string
+	time time.Time
+}
+
+// windowQuery cuts the date_range clause on field of request in windows of
+// a calendar day, week (from Monday) or month of timeZone, the time zone of
+// the clause when empty. It returns a request and its bounds per window.
+func windowQuery(request *gabs.Container, field string, unit string, timeZone string) ([]*gabs.Container, []windowBound, error) {
+	clause := findClause(request.Path("query").Data(), "date_range", field)
+	if clause == nil {
+		return nil, nil, fmt.Errorf("the query has no date_range clause on %q to cut in windows", field)
+	}
+	if tz, _ := clause["time_zone"].(string); timeZone == "" {
+		timeZone = tz
+	} else if tz == "" {
+		// The window bounds are written in timeZone, so DS must read
+		// them in it.
+		clause["time_zone"] = timeZone
+	} else if tz != timeZone {
+		return nil, nil, fmt.Errorf("-window-tz %s is not the time_zone %s of the date_range on %q", timeZone, tz, field)
+	}
+	loc, err := parseTimeZone(timeZone)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var bounds []windowBound
+	split := func(from interface{}, to interface{}, n int) ([]interface{}, error) {
+		var err error
+		bounds, err = splitWindows(from, to, unit, loc)
+		if err != nil {
+			return nil, err
+		}
+		boundaries := make([]interface{}, len(bounds))
+		for i, b := range bounds {
+			boundaries[i] = b.text
+		}
+		return boundaries, nil
+	}
+	requests, err := sliceRange(request, "date_range", field, 0, split)
+	if err != nil {
+		return nil, nil, err
+	}
+	return requests, bounds, nil
+}
+
+// splitWindows cuts [from, to] at every start of a calendar unit of loc.
+// The boundaries are written in the layout of from.
+func splitWindows(from interface{}, to interface{}, unit string, loc *time.Location) ([]windowBound, error) {
+	fromText, ok1 := from.(string)
+	toText, ok2 := to.(string)
+	if !ok1 || !ok2 {
+		return nil, errors.New("bounds are not dates")
+	}
+	start, layout, err := parseDateIn(fromText, loc)
+	if err != nil {
+		return nil, err
+	}
+	end, _, err := parseDateIn(toText, loc)
+	if err != nil {
+		return nil, err
+	}
+	if !end.After(start) {
+		return nil, errors.New("the range is empty")
+	}
+
+	bounds := []windowBound{{fromText, start}}
+	for b := nextWindow(start, unit); b.Before(end); b = nextWindow(b, unit) {
+		bounds = append(bounds, windowBound{b.Format(layout), b})
+	}
+	return append(bounds, windowBound{toText, end}), nil
+}
+
+// nextWindow is the start of the day, week or month after the one of t, in
+// the location of t.
+func nextWindow(t time.Time, unit string) time.Time {
+	y, m, d := t.Date()
+	switch unit {
+	case "week":
+		monday := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d+7-monday, 0, 0, 0, 0, t.Location())
+	case "month":
+		return time.Date(y, m+1, 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+}
+
+// windowLabel names a window after its start: 2019-01 for a month,
+// 2019-01-07 for a day or week.
+func windowLabel(start windowBound, unit string) string {
+	if unit == "month" {
+		return start.time.Format("2006-01")
+	}
+	return start.time.Format("2006-01-02")
+}
+
+// parseDateIn parses a date_range bound, in loc when it has no offset.
+func parseDateIn(text string, loc *time.Location) (time.Time, string, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, text, loc); err == nil {
+			return t.In(loc), layout, nil
+		}
+	}
+	return time.Time{}, "", fmt.Errorf("unsupported date %q", text)
+}
+
+var offsetPattern = regexp.MustCompile(`^([+-])(\d{2}):?(\d{2})$`)
+
+// parseTimeZone parses an offset like -04:00 or a name like
+// America/Argentina/Buenos_Aires. Empty is UTC.
+func parseTimeZone(tz string) (*time.Location, error) {
+	if tz == "" || tz == "Z" {
+		return time.UTC, nil
+	}
+	if m := offsetPattern.FindStringSubmatch(tz); m != nil {
+		hours, _ := strconv.Atoi(m[2])
+		minutes, _ := strconv.Atoi(m[3])
+		offset := hours*3600 + minutes*60
+		if m[1] == "-" {
+			offset = -offset
+		}
+		return time.FixedZone(tz, offset), nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", tz)
+	}
+	return loc, nil
+}
+
+// exportWindows exports every window to its own file, one after the other,
+// recording which ones are done. A failed window doesn't stop the others;
+// rerunning with -resume scrolls the failed windows again, continuing from
+// their checkpoint when their output can be resumed.
+func exportWindows(ctx context.Context, o exportOptions, query *queryFlags) error {
+	state, requests, err := planWindows(o, query)
+	if err != nil {
+		return err
+	}
+	if err := state.save(); err != nil {
+		return err
+	}
+
+	var docs, expected int64
+	failed := 0
+	// Once the windows stop, what's left is the whole export, for metrics.
+	defer func() {
+		exportProgress = newProgress(os.Stderr, o.progress)
+		exportProgress.add(docs, expected)
+	}()
+	for i, w := range state.Windows {
+		if w.Status == windowDone {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		wo := o
+		wo.out = w.File
+		wo.resume = false
+		// On -resume the files of the windows not done are those this
+		// export left.
+		wo.overwrite = o.overwrite || o.resume
+		if w.Status == windowFailed && o.resumable() {
+			_, err := os.Stat(checkpointPath(w.File))
+			wo.resume = err == nil
+		}
+
+		fmt.Printf("window %s: %s to %s\n", w.Label, w.From, w.To)
+		exportProgress = newProgress(os.Stderr, o.progress)
+		err := exportScroll(ctx, wo, requests[i])
+		if err == nil {
+			reportMissing()
+		}
+		docs += exportProgress.documents()
+		expected += exportProgress.expected()
+
+		now := time.Now()
+		w.FinishedAt = &now
+		if !wo.resume {
+			w.Documents = 0
+		}
+		w.Documents += exportProgress.documents()
+		w.Status, w.Error = windowDone, ""
+		if err != nil {
+			w.Status, w.Error = windowFailed, redact(err.Error())
+			failed++
+		}
+		if serr := state.save(); serr != nil {
+			return serr
+		}
+		if errors.Is(err, context.Canceled) {
+			return err
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "window %s failed: %v\n", w.Label, err)
+		}
+	}
+
+	fmt.Printf("%d windows, %d failed, %d documents written, see %s\n", len(state.Windows), failed, docs, state.path)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d windows failed, rerun with -resume to export them again", failed, len(state.Windows))
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSplitWindows(t *testing.T) {
+	buenosAires, err := time.LoadLocation("America/Argentina/Buenos_Aires")
+	if err != nil {
+		t.Skip(err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	for _, c := range []struct {
+		from, to interface{}
+		unit     string
+		loc      *time.Location
+		want     []string
+		err      bool
+	}{
+		{
+			from: "2019-01-30", to: "2019-02-02", unit: "day", loc: time.UTC,
+			want: []string{"2019-01-30", "2019-01-31", "2019-02-01", "2019-02-02"},
+		},
+		{
+			// Windows of a week start on Monday, 2019-01-07 and 2019-01-14.
+			from: "2019-01-03", to: "2019-01-16", unit: "week", loc: time.UTC,
+			want: []string{"2019-01-03", "2019-01-07", "2019-01-14", "2019-01-16"},
+		},
+		{
+			from: "2019-01-15T10:00:00", to: "2019-03-01T00:00:00", unit: "month", loc: time.UTC,
+			want: []string{"2019-01-15T10:00:00", "2019-02-01T00:00:00", "2019-03-01T00:00:00"},
+		},
+		{
+			from: "2019-12-31", to: "2020-01-01", unit: "month", loc: time.UTC,
+			want: []string{"2019-12-31", "2020-01-01"},
+		},
+		{
+			from: "2019-01-01T00:00:00.000-03:00", to: "2019-01-03T00:00:00.000-03:00", unit: "day", loc: buenosAires,
+			want: []string{"2019-01-01T00:00:00.000-03:00", "2019-01-02T00:00:00.000-03:00", "2019-01-03T00:00:00.000-03:00"},
+		},
+		{
+			// Days of 23 hours still start at midnight.
+			from: "2019-03-09", to: "2019-03-12", unit: "day", loc: newYork,
+			want: []string{"2019-03-09", "2019-03-10", "2019-03-11", "2019-03-12"},
+		},
+		{from: "2019-01-02", to: "2019-01-01", unit: "day", loc: time.UTC, err: true},
+		{from: "2019-01-01", to: "soon", unit: "day", loc: time.UTC, err: true},
+		{from: 1546300800000.0, to: "2019-01-02", unit: "day", loc: time.UTC, err: true},
+	} {
+		bounds, err := splitWindows(c.from, c.to, c.unit, c.loc)
+		if c.err {
+			if err == nil {
+				t.Errorf("splitWindows(%v, %v, %s) = %v, want an error", c.from, c.to, c.unit, bounds)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("splitWindows(%v, %v, %s): %v", c.from, c.to, c.unit, err)
+			continue
+		}
+		var got []string
+		for i, b := range bounds {
+			got = append(got, b.text)
+			if i > 0 && !b.time.After(bounds[i-1].time) {
+				t.Errorf("splitWindows(%v, %v, %s): bound %d at %v is not after %v", c.from, c.to, c.unit, i, b.time, bounds[i-1].time)
+			}
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("splitWindows(%v, %v, %s) = %v, want %v", c.from, c.to, c.unit, got, c.want)
+		}
+	}
+}
+
+func TestNextWindow(t *testing.T) {
+	minus4 := time.FixedZone("-04:00", -4*3600)
+	for _, c := range []struct {
+		t    time.Time
+		unit string
+		want time.Time
+	}{
+		{time.Date(2019, 1, 31, 15, 0, 0, 0, time.UTC), "day", time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2019, 1, 7, 0, 0, 0, 0, time.UTC), "week", time.Date(2019, 1, 14, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2019, 1, 13, 23, 0, 0, 0, time.UTC), "week", time.Date(2019, 1, 14, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2019, 1, 31, 0, 0, 0, 0, time.UTC), "month", time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC), "month", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2019, 1, 1, 22, 0, 0, 0, minus4), "day", time.Date(2019, 1, 2, 0, 0, 0, 0, minus4)},
+	} {
+		if got := nextWindow(c.t, c.unit); !got.Equal(c.want) {
+			t.Errorf("nextWindow(%v, %s) = %v, want %v", c.t, c.unit, got, c.want)
+		}
+	}
+}
+
+func TestParseTimeZone(t *testing.T) {
+	for _, c := range []struct {
+		tz     string
+		offset int
+		err    bool
+	}{
+		{tz: "", offset: 0},
+		{tz: "Z", offset: 0},
+		{tz: "-04:00", offset: -4 * 3600},
+		{tz: "+0530", offset: 5*3600 + 30*60},
+		{tz: "America/Argentina/Buenos_Aires", offset: -3 * 3600},
+		{tz: "Mars/Olympus_Mons", err: true},
+		{tz: "-4", err: true},
+	} {
+		loc, err := parseTimeZone(c.tz)
+		if c.err {
+			if err == nil {
+				t.Errorf("parseTimeZone(%q) = %v, want an error", c.tz, loc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTimeZone(%q): %v", c.tz, err)
+			continue
+		}
+		if _, offset := time.Date(2019, 6, 1, 0, 0, 0, 0, loc).Zone(); offset != c.offset {
+			t.Errorf("parseTimeZone(%q) is %d seconds from UTC, want %d", c.tz, offset, c.offset)
+		}
+	}
+}